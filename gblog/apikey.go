@@ -0,0 +1,270 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type Scope string
+
+// API key 的权限范围, 与用户角色的权限取交集
+const (
+	ScopePostsRead         Scope = "posts:read"
+	ScopePostsWrite        Scope = "posts:write"
+	ScopeCommentsRead      Scope = "comments:read"
+	ScopeCommentsWrite     Scope = "comments:write"
+	ScopeNotificationsRead Scope = "notifications:read"
+	ScopeProfileRead       Scope = "profile:read"
+)
+
+var allScopes = []Scope{ScopePostsRead, ScopePostsWrite, ScopeCommentsRead, ScopeCommentsWrite, ScopeNotificationsRead, ScopeProfileRead}
+
+// 接口需要的 scope, key 为 "方法 路由"; 不在表中的接口(账号设置, 管理等)不接受 API key
+var routeScopes = map[string]Scope{
+	"GET /auth/posts":                            ScopePostsRead,
+	"GET /auth/search":                           ScopePostsRead,
+	"GET /auth/post/:id":                         ScopePostsRead,
+	"GET /auth/post/:id/revisions":               ScopePostsRead,
+	"GET /auth/post/:id/revisions/diff":          ScopePostsRead,
+	"GET /auth/tags":                             ScopePostsRead,
+	"GET /auth/categories":                       ScopePostsRead,
+	"GET /auth/feed":                             ScopePostsRead,
+	"GET /auth/bookmarks":                        ScopePostsRead,
+	"POST /auth/markdown/preview":                ScopePostsWrite,
+	"POST /auth/upload":                          ScopePostsWrite,
+	"POST /auth/post/:id/attachments":            ScopePostsWrite,
+	"DELETE /auth/attachment/:id":                ScopePostsWrite,
+	"POST /auth/post":                            ScopePostsWrite,
+	"PUT /auth/post/:id":                         ScopePostsWrite,
+	"DELETE /auth/post/:id":                      ScopePostsWrite,
+	"POST /auth/post/:id/revisions/:rev/restore": ScopePostsWrite,
+	"POST /auth/post/:id/tags":                   ScopePostsWrite,
+	"DELETE /auth/post/:id/tags/:name":           ScopePostsWrite,
+	"POST /auth/categories":                      ScopePostsWrite,
+	"GET /auth/post/:id/comments":                ScopeCommentsRead,
+	"GET /auth/post/:id/comments/stream":         ScopeCommentsRead,
+	"POST /auth/post/:id/comment":                ScopeCommentsWrite,
+	"PUT /auth/comment/:id":                      ScopeCommentsWrite,
+	"DELETE /auth/comment/:id":                   ScopeCommentsWrite,
+	"GET /auth/notifications":                    ScopeNotificationsRead,
+	"GET /auth/notifications/unread_count":       ScopeNotificationsRead,
+	"GET /auth/notifications/stream":             ScopeNotificationsRead,
+	"GET /auth/me":                               ScopeProfileRead,
+	"GET /auth/users/:id/followers":              ScopeProfileRead,
+	"GET /auth/users/:id/following":              ScopeProfileRead,
+}
+
+const (
+	apiKeyPrefix = "gbk_" // 以此开头的 Bearer 令牌按 API key 处理
+	maxAPIKeys   = 20     // 每个用户最多同时有效的 key
+	// 最近使用时间的更新间隔, 避免每个请求都写数据库
+	apiKeyTouchInterval = time.Minute
+)
+
+// 个人 API key, 只保存哈希
+type APIKey struct {
+	ID         uint       `gorm:"primarykey"`
+	UserID     uint       `gorm:"index"`
+	Name       string     `gorm:"size:64"`
+	Prefix     string     `gorm:"size:16"` // key 的前几位, 用于在列表中辨认
+	KeyHash    string     `gorm:"size:64;uniqueIndex"`
+	Scopes     string     `gorm:"size:255"` // 逗号分隔
+	ExpiresAt  *time.Time // 为空表示不过期
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"size:64"`
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (k *APIKey) HasScope(scope Scope) bool {
+	return slices.Contains(strings.Split(k.Scopes, ","), string(scope))
+}
+
+// 解析逗号分隔或重复提交的 scopes 参数, 去重并按 allScopes 的顺序排列
+func parseScopes(values []string) ([]string, bool) {
+	wanted := map[string]bool{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				wanted[s] = true
+			}
+		}
+	}
+	scopes := make([]string, 0, len(wanted))
+	for _, s := range allScopes {
+		if wanted[string(s)] {
+			scopes = append(scopes, string(s))
+			delete(wanted, string(s))
+		}
+	}
+	return scopes, len(wanted) == 0 && len(scopes) > 0
+}
+
+// 用 API key 认证, 由 JwtAuthMiddleware 调用; 失败时已写入响应并中止
+func authenticateAPIKey(c *gin.Context, raw string) bool {
+	key, err := store.APIKeys.GetByHash(hashToken(raw))
+	now := time.Now()
+	if err != nil || key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "api key is invalid"})
+		c.Abort()
+		return false
+	}
+	scope, ok := routeScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok || !key.HasScope(scope) {
+		zap.L().Warn("api key scope denied", zap.Uint("api_key_id", key.ID), zap.String("scope", string(scope)), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusForbidden, gin.H{"error": "api key is not allowed to access this endpoint"})
+		c.Abort()
+		return false
+	}
+	// 角色以数据库为准, 修改角色后立即生效
+	user, err := store.Users.GetByID(key.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "api key is invalid"})
+		c.Abort()
+		return false
+	}
+	if err := store.APIKeys.Touch(key.ID, c.ClientIP(), now, now.Add(-apiKeyTouchInterval)); err != nil {
+		zap.L().Error("touch api key failed", zap.Uint("api_key_id", key.ID), zap.Error(err))
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("apiKeyID", key.ID)
+	return true
+}
+
+func timeJSON(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func apiKeyJSON(k *APIKey) gin.H {
+	return gin.H{
+		"id":           k.ID,
+		"name":         k.Name,
+		"prefix":       k.Prefix,
+		"scopes":       strings.Split(k.Scopes, ","),
+		"created":      k.CreatedAt.Format("2006-01-02 15:04:05"),
+		"expires_at":   timeJSON(k.ExpiresAt),
+		"last_used_at": timeJSON(k.LastUsedAt),
+		"last_used_ip": k.LastUsedIP,
+		"revoked_at":   timeJSON(k.RevokedAt),
+	}
+}
+
+// POST /auth/me/api-keys 创建 API key, 参数 name, scopes(逗号分隔), expires_in(天, 默认 90, 0 表示不过期)
+// 返回的 key 只显示这一次
+func CreateAPIKeyHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" || len(name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-64 characters"})
+		return
+	}
+	scopes, valid := parseScopes(c.PostFormArray("scopes"))
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopes must be a non-empty list of: posts:read, posts:write, comments:read, comments:write, notifications:read, profile:read"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultPostForm("expires_in", "90"))
+	if err != nil || days < 0 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be 0-365 days"})
+		return
+	}
+
+	count, err := store.APIKeys.CountActive(uid, time.Now())
+	if err != nil {
+		zap.L().Error("CreateAPIKey failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count >= maxAPIKeys {
+		c.JSON(http.StatusConflict, gin.H{"error": "too many api keys, revoke unused keys first"})
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "api key generate failed"})
+		return
+	}
+	raw := apiKeyPrefix + secret
+	key := &APIKey{
+		UserID:  uid,
+		Name:    name,
+		Prefix:  raw[:len(apiKeyPrefix)+6],
+		KeyHash: hashToken(raw),
+		Scopes:  strings.Join(scopes, ","),
+	}
+	if days > 0 {
+		exp := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		key.ExpiresAt = &exp
+	}
+	if err := store.APIKeys.Create(key); err != nil {
+		zap.L().Error("CreateAPIKey failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	zap.L().Info("CreateAPIKey successfully", zap.Uint("user_id", uid), zap.Uint("api_key_id", key.ID), zap.String("scopes", key.Scopes))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"key":     raw,
+		"api_key": apiKeyJSON(key),
+	})
+}
+
+// GET /auth/me/api-keys 当前用户的 API key, 包含已吊销和已过期的
+func ListAPIKeysHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	apiKeys, err := store.APIKeys.ListByUser(uid)
+	if err != nil {
+		zap.L().Error("ListAPIKeys failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := make([]gin.H, 0, len(apiKeys))
+	for i := range apiKeys {
+		list = append(list, apiKeyJSON(&apiKeys[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "api_keys": list})
+}
+
+// DELETE /auth/me/api-keys/:id 吊销 API key, 立即生效
+func RevokeAPIKeyHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api key id format is not correct"})
+		return
+	}
+	if err := store.APIKeys.Revoke(uid, uint(id)); err != nil {
+		if err == ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+		zap.L().Error("RevokeAPIKey failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("RevokeAPIKey successfully", zap.Uint("user_id", uid), zap.Uint64("api_key_id", id))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
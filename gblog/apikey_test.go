@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func createTestAPIKey(t *testing.T, h http.Handler, token, scopes string) (string, uint) {
+	t.Helper()
+	w, data := doRequest(t, h, http.MethodPost, "/auth/me/api-keys", token, map[string]string{"name": "test", "scopes": scopes})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create api key: status %d: %s", w.Code, w.Body.String())
+	}
+	return data["key"].(string), uint(data["api_key"].(map[string]interface{})["id"].(float64))
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	r := newTestServer(t, nil)
+	token := registerTestUser(t, r, "alice")
+	key, id := createTestAPIKey(t, r, token, "posts:read")
+
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/posts", key, nil); w.Code != http.StatusOK {
+		t.Errorf("list posts with posts:read: status %d, want 200", w.Code)
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/auth/post", key, map[string]string{"title": "t", "content": "c"}); w.Code != http.StatusForbidden {
+		t.Errorf("create post with posts:read: status %d, want 403", w.Code)
+	}
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/me", key, nil); w.Code != http.StatusForbidden {
+		t.Errorf("get profile with posts:read: status %d, want 403", w.Code)
+	}
+
+	if w, _ := doRequest(t, r, http.MethodDelete, fmt.Sprintf("/auth/me/api-keys/%d", id), token, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke api key: status %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/posts", key, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked api key: status %d, want 401", w.Code)
+	}
+}
+
+// 不在 routeScopes 中的 /auth 接口即使 key 拥有全部 scope 也拒绝
+func TestAPIKeyDeniedByDefault(t *testing.T) {
+	r := newTestServer(t, nil)
+	token := registerTestUser(t, r, "alice")
+	scopes := make([]string, 0, len(allScopes))
+	for _, s := range allScopes {
+		scopes = append(scopes, string(s))
+	}
+	key, _ := createTestAPIKey(t, r, token, strings.Join(scopes, ","))
+
+	routes := map[string]bool{}
+	for _, route := range r.Routes() {
+		routes[route.Method+" "+route.Path] = true
+		if !strings.HasPrefix(route.Path, "/auth/") || routeScopes[route.Method+" "+route.Path] != "" {
+			continue
+		}
+		segments := strings.Split(route.Path, "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+				segments[i] = "1"
+			}
+		}
+		path := strings.Join(segments, "/")
+		if w, _ := doRequest(t, r, route.Method, path, key, map[string]string{}); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with api key: status %d, want 403", route.Method, path, w.Code)
+		}
+	}
+	// 表中的路由必须存在, 避免改名后 scope 失效
+	for route := range routeScopes {
+		if !routes[route] {
+			t.Errorf("routeScopes has unknown route %q", route)
+		}
+	}
+}
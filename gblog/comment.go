@@ -11,27 +11,84 @@ import (
 
 type Comment struct {
 	gorm.Model
-	Content string
-	UserID  uint
-	User    User
-	PostID  uint
-	Post    Post
+	Content  string
+	UserID   uint
+	User     User
+	PostID   uint
+	Post     Post
+	ParentID *uint `gorm:"index"` // 回复的评论, 为空表示直接评论文章
+	// 互动计数, 由 EngagementRepository 同步更新
+	LikeCount      int64
+	ReactionCounts []ReactionCount `gorm:"polymorphic:Target;polymorphicValue:comment"`
 }
 
 type CreateCommentReq struct {
-	Content string
-	PostID  uint
+	Content  string `form:"content" binding:"required,min=1"`
+	ParentID uint   `form:"parent_id"`
+}
+
+type UpdateCommentReq struct {
+	Content string `form:"content" binding:"required,min=1"`
+}
+
+func validateCommentID(c *gin.Context) (uint, bool) {
+	cid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "comment_id format is not correct"})
+		return 0, false
+	}
+	return uint(cid), true
+}
+
+func commentJSON(comment *Comment) gin.H {
+	return gin.H{
+		"id":           comment.ID,
+		"content":      comment.Content,
+		"content_html": renderMarkdown(comment.Content),
+		"post_id":      comment.PostID,
+		"user_id":      comment.UserID,
+		"username":     comment.User.Username,
+		"parent_id":    comment.ParentID,
+		"like_count":   comment.LikeCount,
+		"reactions":    reactionsJSON(comment.ReactionCounts),
+		"created":      comment.CreatedAt.Format("2006-01-02 15:04:05"),
+		"updated":      comment.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// 将评论列表组装成树, 每个节点的 replies 为其回复
+func buildCommentTree(comments []Comment) []gin.H {
+	nodes := make(map[uint]gin.H, len(comments))
+	for i := range comments {
+		node := commentJSON(&comments[i])
+		node["replies"] = []gin.H{}
+		nodes[comments[i].ID] = node
+	}
+
+	roots := []gin.H{}
+	for _, comment := range comments {
+		node := nodes[comment.ID]
+		if comment.ParentID != nil {
+			if parent, ok := nodes[*comment.ParentID]; ok {
+				parent["replies"] = append(parent["replies"].([]gin.H), node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
 }
 
 func CreateCommentHandler(c *gin.Context) {
-	pidStr := c.Param("id")
-	if pidStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "post id is null"})
+	pid, ok := validatePostID(c)
+	if !ok {
 		return
 	}
-	pid, err := strconv.ParseUint(pidStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "post_id format is not correct"})
+
+	var req CreateCommentReq
+	if err := c.ShouldBind(&req); err != nil {
+		zap.L().Error("CreateComment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -40,26 +97,140 @@ func CreateCommentHandler(c *gin.Context) {
 		return
 	}
 
+	post, err := store.Posts.GetByID(pid)
+	if err != nil || !canViewPost(c, post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get post"})
+		return
+	}
+
 	comment := &Comment{
-		Content: c.PostForm("content"),
+		Content: req.Content,
 		UserID:  uid,
-		PostID:  uint(pid),
+		PostID:  pid,
+	}
+	var parent *Comment
+	if req.ParentID != 0 {
+		parent, err = store.Comments.GetByID(req.ParentID)
+		if err != nil || parent.PostID != pid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment is not in the post"})
+			return
+		}
+		comment.ParentID = &parent.ID
 	}
-	if err := db.Create(&comment).Error; err != nil {
+	if err := store.Comments.Create(comment); err != nil {
+		zap.L().Error("CreateComment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	searchIndex.IndexComment(comment)
+	notifyComment(post, comment, parent)
+	comment.User.Username = c.GetString("username")
+	liveComments.Publish(pid, EventCommentCreated, commentJSON(comment))
+
+	zap.L().Info("CreateComment successfully", zap.Uint("comment_id", comment.ID), zap.Uint("post_id", pid), zap.Uint("user_id", uid))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"comment": gin.H{
-			"content": comment.Content,
-			"post_id": comment.PostID,
-			"user_id": comment.UserID,
+			"id":           comment.ID,
+			"content":      comment.Content,
+			"content_html": renderMarkdown(comment.Content),
+			"post_id":      comment.PostID,
+			"user_id":      comment.UserID,
+			"parent_id":    comment.ParentID,
 		},
 	})
 }
 
+// 只有评论作者可以编辑
+func UpdateCommentHandler(c *gin.Context) {
+	cid, ok := validateCommentID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateCommentReq
+	if err := c.ShouldBind(&req); err != nil {
+		zap.L().Error("UpdateComment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	comment, err := store.Comments.GetByID(cid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get comment"})
+		return
+	}
+	if comment.UserID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "comment is not belongs to the user"})
+		return
+	}
+
+	if err := store.Comments.Update(comment, map[string]interface{}{"Content": req.Content}); err != nil {
+		zap.L().Error("UpdateComment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment.Content = req.Content
+	searchIndex.IndexComment(comment)
+	liveComments.Publish(comment.PostID, EventCommentUpdated, commentJSON(comment))
+
+	zap.L().Info("UpdateComment successfully", zap.Uint("comment_id", comment.ID), zap.Uint("user_id", uid))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"comment": commentJSON(comment),
+	})
+}
+
+// 评论作者, 文章作者或拥有 comment:delete_any 权限的角色可以删除, 回复一并删除
+func DeleteCommentHandler(c *gin.Context) {
+	cid, ok := validateCommentID(c)
+	if !ok {
+		return
+	}
+
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	comment, err := store.Comments.GetByID(cid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get comment"})
+		return
+	}
+	if comment.UserID != uid && !getCurrentRole(c).Can(PermCommentDeleteAny) {
+		post, err := store.Posts.GetByID(comment.PostID)
+		if err != nil || post.UserID != uid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "comment is not belongs to the user"})
+			return
+		}
+	}
+
+	deleted, err := store.Comments.DeleteTree(comment)
+	if err != nil {
+		zap.L().Error("DeleteComment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	searchIndex.RemoveComments(deleted...)
+	liveComments.Publish(comment.PostID, EventCommentDeleted, gin.H{"comment_id": comment.ID, "deleted_ids": deleted})
+
+	zap.L().Info("DeleteComment successfully", zap.Uint("comment_id", comment.ID), zap.Uint("user_id", uid), zap.Int("deleted", len(deleted)))
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"comment_id":  comment.ID,
+		"deleted_ids": deleted,
+	})
+}
+
 func GetCommentsByPostID(c *gin.Context) {
 	pidStr := c.Param("id")
 	if pidStr == "" {
@@ -74,8 +245,13 @@ func GetCommentsByPostID(c *gin.Context) {
 		return
 	}
 
-	var comments []Comment
-	if err := db.Where("post_id = ?", pid).Find(&comments).Error; err != nil {
+	if post, err := store.Posts.GetByID(uint(pid)); err != nil || !canViewPost(c, post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get post"})
+		return
+	}
+
+	comments, err := store.Comments.ListByPostID(uint(pid))
+	if err != nil {
 		zap.L().Error("GetCommentsByPostID failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -84,6 +260,7 @@ func GetCommentsByPostID(c *gin.Context) {
 	zap.L().Info("GetCommentsByPostID successfully", zap.Uint("post_id", uint(pid)))
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
-		"comments": comments,
+		"total":    len(comments),
+		"comments": buildCommentTree(comments),
 	})
 }
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// 配置优先级: 默认值 < 配置文件(yaml/toml) < 环境变量 < 命令行参数
+type Config struct {
+	Env    string       `yaml:"env" toml:"env"`
+	Site   SiteConfig   `yaml:"site" toml:"site"`
+	Server ServerConfig `yaml:"server" toml:"server"`
+	DB     DBConfig     `yaml:"db" toml:"db"`
+	JWT    JWTConfig    `yaml:"jwt" toml:"jwt"`
+	Log    LogConfig    `yaml:"log" toml:"log"`
+	SIWE   SIWEConfig   `yaml:"siwe" toml:"siwe"`
+	Upload UploadConfig `yaml:"upload" toml:"upload"`
+	Feed   FeedConfig   `yaml:"feed" toml:"feed"`
+	Mail   MailConfig   `yaml:"mail" toml:"mail"`
+	Login  LoginConfig  `yaml:"login" toml:"login"`
+	OIDC   OIDCConfig   `yaml:"oidc" toml:"oidc"`
+	Admins []string     `yaml:"admins" toml:"admins"` // 启动时设为管理员的用户名
+}
+
+// 站点信息, 用于 RSS/Atom/JSON Feed
+type SiteConfig struct {
+	Title       string `yaml:"title" toml:"title"`
+	URL         string `yaml:"url" toml:"url"` // 对外访问地址, 文章链接为 {url}/post/{id}
+	Description string `yaml:"description" toml:"description"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// 信任其 X-Forwarded-For 的反向代理地址或网段, 为空时直接使用连接地址作为客户端 IP
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type DBConfig struct {
+	Driver string `yaml:"driver" toml:"driver"`
+	DSN    string `yaml:"dsn" toml:"dsn"`
+}
+
+type JWTConfig struct {
+	Algorithm     string   `yaml:"algorithm" toml:"algorithm"`           // HS256 / RS256 / EdDSA
+	Secret        string   `yaml:"secret" toml:"secret"`                 // 仅 HS256 使用
+	KeyRotate     Duration `yaml:"key_rotate" toml:"key_rotate"`         // 非对称密钥轮换周期
+	KeyGrace      Duration `yaml:"key_grace" toml:"key_grace"`           // 轮换后旧密钥继续用于验证的时间
+	Expire        Duration `yaml:"expire" toml:"expire"`                 // access token 有效期
+	RefreshExpire Duration `yaml:"refresh_expire" toml:"refresh_expire"` // refresh token 有效期
+}
+
+// Sign-In With Ethereum
+type SIWEConfig struct {
+	Domain      string   `yaml:"domain" toml:"domain"`             // 消息中必须出现的域名
+	ChainID     int64    `yaml:"chain_id" toml:"chain_id"`         // 0 表示不限制
+	NonceExpire Duration `yaml:"nonce_expire" toml:"nonce_expire"` // nonce 有效期
+}
+
+// 首页时间线
+type FeedConfig struct {
+	// 关注人数不少于该值的用户使用预计算时间线, 0 表示全部实时查询
+	PrecomputeThreshold int `yaml:"precompute_threshold" toml:"precompute_threshold"`
+}
+
+// 邮件发送, 用于邮箱验证和重置密码
+type MailConfig struct {
+	Backend      string     `yaml:"backend" toml:"backend"` // smtp, file(写入目录) 或 memory
+	From         string     `yaml:"from" toml:"from"`
+	Dir          string     `yaml:"dir" toml:"dir"` // file 后端的输出目录
+	SMTP         SMTPConfig `yaml:"smtp" toml:"smtp"`
+	VerifyExpire Duration   `yaml:"verify_expire" toml:"verify_expire"` // 邮箱验证链接有效期
+	ResetExpire  Duration   `yaml:"reset_expire" toml:"reset_expire"`   // 重置密码链接有效期
+	// 重置密码请求限制: 同一邮箱或 IP 在 reset_window 内请求达到次数后锁定 reset_window, 0 表示不限制
+	ResetLimit   int      `yaml:"reset_limit" toml:"reset_limit"`
+	ResetIPLimit int      `yaml:"reset_ip_limit" toml:"reset_ip_limit"`
+	ResetWindow  Duration `yaml:"reset_window" toml:"reset_window"`
+}
+
+// 登录失败限制, 账号和 IP 分别计数
+type LoginConfig struct {
+	MaxFailures    int      `yaml:"max_failures" toml:"max_failures"`       // 同一账号连续失败该次数后锁定, 0 表示不限制
+	IPMaxFailures  int      `yaml:"ip_max_failures" toml:"ip_max_failures"` // 同一 IP 连续失败该次数后锁定, 0 表示不限制
+	FailureWindow  Duration `yaml:"failure_window" toml:"failure_window"`   // 超过该时间没有失败则重新计数
+	LockoutBase    Duration `yaml:"lockout_base" toml:"lockout_base"`       // 首次锁定时长, 之后每次失败翻倍
+	LockoutMax     Duration `yaml:"lockout_max" toml:"lockout_max"`         // 最长锁定时长
+	AuditRetention Duration `yaml:"audit_retention" toml:"audit_retention"` // 失败记录保留时间
+	// 开启两步验证的用户密码正确后, 提交验证码的期限
+	ChallengeExpire Duration `yaml:"challenge_expire" toml:"challenge_expire"`
+}
+
+// OpenID Connect 第三方登录(授权码 + PKCE)
+type OIDCConfig struct {
+	Providers   []OIDCProviderConfig `yaml:"providers" toml:"providers"`
+	StateExpire Duration             `yaml:"state_expire" toml:"state_expire"` // 跳转到提供方后完成登录的期限
+	Mock        bool                 `yaml:"mock" toml:"mock"`                 // 启用内置的模拟提供方 mock, 只能用于 dev
+}
+
+type OIDCProviderConfig struct {
+	Name         string   `yaml:"name" toml:"name"`                   // 路由中的名称, 如 google
+	Issuer       string   `yaml:"issuer" toml:"issuer"`               // 从 {issuer}/.well-known/openid-configuration 读取端点
+	ClientID     string   `yaml:"client_id" toml:"client_id"`         // 回调地址为 {site.url}/oidc/{name}/callback
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"` // 建议通过 GBLOG_OIDC_{NAME}_CLIENT_SECRET 注入
+	Scopes       []string `yaml:"scopes" toml:"scopes"`               // 默认 openid email profile
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+// 附件上传
+type UploadConfig struct {
+	Backend   string   `yaml:"backend" toml:"backend"`       // local 或 s3
+	Dir       string   `yaml:"dir" toml:"dir"`               // local 存储目录
+	MaxSize   int      `yaml:"max_size" toml:"max_size"`     // 单个文件最大MB
+	ThumbSize int      `yaml:"thumb_size" toml:"thumb_size"` // 缩略图最长边像素
+	S3        S3Config `yaml:"s3" toml:"s3"`
+}
+
+// S3 兼容的对象存储(AWS S3, MinIO 等)
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint"`
+	Region    string `yaml:"region" toml:"region"`
+	Bucket    string `yaml:"bucket" toml:"bucket"`
+	AccessKey string `yaml:"access_key" toml:"access_key"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	UseSSL    bool   `yaml:"use_ssl" toml:"use_ssl"`
+}
+
+type LogConfig struct {
+	Filename   string `yaml:"filename" toml:"filename"`
+	MaxSize    int    `yaml:"max_size" toml:"max_size"`       // 单个文件最大MB
+	MaxBackups int    `yaml:"max_backups" toml:"max_backups"` // 最多保留备份文件数
+	MaxAge     int    `yaml:"max_age" toml:"max_age"`         // 保留天数
+	Compress   bool   `yaml:"compress" toml:"compress"`       // 压缩旧日志
+}
+
+// 支持 "24h", "15m" 形式的时间配置
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+var cfg *Config
+
+// 默认配置, 不包含任何密钥
+func defaultConfig() *Config {
+	return &Config{
+		Env:    "dev",
+		Site:   SiteConfig{Title: "GBlog", URL: "http://localhost:8080"},
+		Server: ServerConfig{Addr: ":8080"},
+		DB:     DBConfig{Driver: "sqlite", DSN: "gblog.db"},
+		JWT: JWTConfig{
+			Algorithm:     "EdDSA",
+			KeyRotate:     Duration{30 * 24 * time.Hour},
+			KeyGrace:      Duration{24 * time.Hour},
+			Expire:        Duration{15 * time.Minute},
+			RefreshExpire: Duration{7 * 24 * time.Hour},
+		},
+		Log: LogConfig{
+			Filename:   "./logs/gblog.log",
+			MaxSize:    10,
+			MaxBackups: 30,
+			MaxAge:     7,
+			Compress:   true,
+		},
+		SIWE: SIWEConfig{Domain: "localhost:8080", NonceExpire: Duration{5 * time.Minute}},
+		Upload: UploadConfig{
+			Backend:   "local",
+			Dir:       "./uploads",
+			MaxSize:   10,
+			ThumbSize: 320,
+			S3:        S3Config{UseSSL: true},
+		},
+		Mail: MailConfig{
+			Backend:      "file",
+			From:         "gblog@localhost",
+			Dir:          "./mail",
+			SMTP:         SMTPConfig{Port: 587},
+			VerifyExpire: Duration{24 * time.Hour},
+			ResetExpire:  Duration{30 * time.Minute},
+			ResetLimit:   3,
+			ResetIPLimit: 10,
+			ResetWindow:  Duration{time.Hour},
+		},
+		Login: LoginConfig{
+			MaxFailures:     5,
+			IPMaxFailures:   20,
+			FailureWindow:   Duration{15 * time.Minute},
+			LockoutBase:     Duration{time.Minute},
+			LockoutMax:      Duration{time.Hour},
+			AuditRetention:  Duration{30 * 24 * time.Hour},
+			ChallengeExpire: Duration{5 * time.Minute},
+		},
+		OIDC: OIDCConfig{StateExpire: Duration{10 * time.Minute}},
+	}
+}
+
+// 按优先级加载配置并校验
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("gblog", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("GBLOG_CONFIG"), "config file path (.yaml/.yml/.toml)")
+	env := fs.String("env", "", "running env: dev or prod")
+	addr := fs.String("addr", "", "listen address")
+	dbDriver := fs.String("db-driver", "", "db driver: mysql, postgres or sqlite")
+	dbDSN := fs.String("db-dsn", "", "db dsn")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := defaultConfig()
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// 只覆盖命令行中显式设置的参数
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			c.Env = *env
+		case "addr":
+			c.Server.Addr = *addr
+		case "db-driver":
+			c.DB.Driver = *dbDriver
+		case "db-dsn":
+			c.DB.DSN = *dbDSN
+		}
+	})
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 根据扩展名解析配置文件
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml/.yml/.toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// 环境变量覆盖, 密钥类配置建议只通过环境变量注入
+func (c *Config) loadEnv() error {
+	strs := map[string]*string{
+		"GBLOG_ENV":            &c.Env,
+		"GBLOG_ADDR":           &c.Server.Addr,
+		"GBLOG_SITE_TITLE":     &c.Site.Title,
+		"GBLOG_SITE_URL":       &c.Site.URL,
+		"GBLOG_DB_DRIVER":      &c.DB.Driver,
+		"GBLOG_DB_DSN":         &c.DB.DSN,
+		"GBLOG_JWT_ALGORITHM":  &c.JWT.Algorithm,
+		"GBLOG_JWT_SECRET":     &c.JWT.Secret,
+		"GBLOG_LOG_FILENAME":   &c.Log.Filename,
+		"GBLOG_SIWE_DOMAIN":    &c.SIWE.Domain,
+		"GBLOG_UPLOAD_BACKEND": &c.Upload.Backend,
+		"GBLOG_UPLOAD_DIR":     &c.Upload.Dir,
+		"GBLOG_S3_ENDPOINT":    &c.Upload.S3.Endpoint,
+		"GBLOG_S3_REGION":      &c.Upload.S3.Region,
+		"GBLOG_S3_BUCKET":      &c.Upload.S3.Bucket,
+		"GBLOG_S3_ACCESS_KEY":  &c.Upload.S3.AccessKey,
+		"GBLOG_S3_SECRET_KEY":  &c.Upload.S3.SecretKey,
+		"GBLOG_MAIL_BACKEND":   &c.Mail.Backend,
+		"GBLOG_MAIL_FROM":      &c.Mail.From,
+		"GBLOG_MAIL_DIR":       &c.Mail.Dir,
+		"GBLOG_SMTP_HOST":      &c.Mail.SMTP.Host,
+		"GBLOG_SMTP_USERNAME":  &c.Mail.SMTP.Username,
+		"GBLOG_SMTP_PASSWORD":  &c.Mail.SMTP.Password,
+	}
+	for key, ptr := range strs {
+		if v, ok := os.LookupEnv(key); ok {
+			*ptr = v
+		}
+	}
+
+	ints := map[string]*int{
+		"GBLOG_LOG_MAX_SIZE":              &c.Log.MaxSize,
+		"GBLOG_LOG_MAX_BACKUPS":           &c.Log.MaxBackups,
+		"GBLOG_LOG_MAX_AGE":               &c.Log.MaxAge,
+		"GBLOG_UPLOAD_MAX_SIZE":           &c.Upload.MaxSize,
+		"GBLOG_UPLOAD_THUMB_SIZE":         &c.Upload.ThumbSize,
+		"GBLOG_FEED_PRECOMPUTE_THRESHOLD": &c.Feed.PrecomputeThreshold,
+		"GBLOG_SMTP_PORT":                 &c.Mail.SMTP.Port,
+		"GBLOG_LOGIN_MAX_FAILURES":        &c.Login.MaxFailures,
+		"GBLOG_LOGIN_IP_MAX_FAILURES":     &c.Login.IPMaxFailures,
+		"GBLOG_MAIL_RESET_LIMIT":          &c.Mail.ResetLimit,
+		"GBLOG_MAIL_RESET_IP_LIMIT":       &c.Mail.ResetIPLimit,
+	}
+	for key, ptr := range ints {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("env %s: %q is not an integer", key, v)
+			}
+			*ptr = n
+		}
+	}
+
+	durations := map[string]*Duration{
+		"GBLOG_JWT_EXPIRE":             &c.JWT.Expire,
+		"GBLOG_JWT_REFRESH_EXPIRE":     &c.JWT.RefreshExpire,
+		"GBLOG_JWT_KEY_ROTATE":         &c.JWT.KeyRotate,
+		"GBLOG_JWT_KEY_GRACE":          &c.JWT.KeyGrace,
+		"GBLOG_SIWE_NONCE_EXPIRE":      &c.SIWE.NonceExpire,
+		"GBLOG_MAIL_VERIFY_EXPIRE":     &c.Mail.VerifyExpire,
+		"GBLOG_MAIL_RESET_EXPIRE":      &c.Mail.ResetExpire,
+		"GBLOG_MAIL_RESET_WINDOW":      &c.Mail.ResetWindow,
+		"GBLOG_LOGIN_FAILURE_WINDOW":   &c.Login.FailureWindow,
+		"GBLOG_LOGIN_LOCKOUT_BASE":     &c.Login.LockoutBase,
+		"GBLOG_LOGIN_LOCKOUT_MAX":      &c.Login.LockoutMax,
+		"GBLOG_LOGIN_AUDIT_RETENTION":  &c.Login.AuditRetention,
+		"GBLOG_LOGIN_CHALLENGE_EXPIRE": &c.Login.ChallengeExpire,
+		"GBLOG_OIDC_STATE_EXPIRE":      &c.OIDC.StateExpire,
+	}
+	for key, ptr := range durations {
+		if v, ok := os.LookupEnv(key); ok {
+			if err := ptr.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("env %s: %w", key, err)
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("GBLOG_ADMINS"); ok {
+		c.Admins = strings.Split(v, ",")
+	}
+	if v, ok := os.LookupEnv("GBLOG_TRUSTED_PROXIES"); ok {
+		c.Server.TrustedProxies = nil
+		if v != "" {
+			c.Server.TrustedProxies = strings.Split(v, ",")
+		}
+	}
+	if v, ok := os.LookupEnv("GBLOG_LOG_COMPRESS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("env GBLOG_LOG_COMPRESS: %q is not a bool", v)
+		}
+		c.Log.Compress = b
+	}
+	if v, ok := os.LookupEnv("GBLOG_OIDC_MOCK"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("env GBLOG_OIDC_MOCK: %q is not a bool", v)
+		}
+		c.OIDC.Mock = b
+	}
+	for i := range c.OIDC.Providers {
+		p := &c.OIDC.Providers[i]
+		key := "GBLOG_OIDC_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_CLIENT_SECRET"
+		if v, ok := os.LookupEnv(key); ok {
+			p.ClientSecret = v
+		}
+	}
+	return nil
+}
+
+// 校验配置, 返回所有错误
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Env != "dev" && c.Env != "prod" {
+		errs = append(errs, fmt.Errorf("env: must be dev or prod, got %q", c.Env))
+	}
+	if c.Site.Title == "" {
+		errs = append(errs, errors.New("site.title: must not be empty"))
+	}
+	if u, err := url.Parse(c.Site.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("site.url: must be an absolute http(s) url, got %q", c.Site.URL))
+	}
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr: must not be empty"))
+	}
+	if _, err := dialector(c.DB.Driver, c.DB.DSN); err != nil {
+		errs = append(errs, fmt.Errorf("db.driver: %w", err))
+	}
+	if c.DB.DSN == "" {
+		errs = append(errs, errors.New("db.dsn: must not be empty (set GBLOG_DB_DSN)"))
+	}
+	if _, err := signingMethod(c.JWT.Algorithm); err != nil {
+		errs = append(errs, fmt.Errorf("jwt.algorithm: %w", err))
+	}
+	if c.JWT.Algorithm == "HS256" {
+		if c.JWT.Secret == "" {
+			errs = append(errs, errors.New("jwt.secret: must not be empty with HS256 (set GBLOG_JWT_SECRET)"))
+		} else if len(c.JWT.Secret) < 16 {
+			errs = append(errs, errors.New("jwt.secret: must be at least 16 bytes"))
+		}
+	} else {
+		if c.JWT.KeyRotate.Duration <= 0 {
+			errs = append(errs, errors.New("jwt.key_rotate: must be positive"))
+		}
+		if c.JWT.KeyGrace.Duration < c.JWT.Expire.Duration {
+			errs = append(errs, errors.New("jwt.key_grace: must not be shorter than jwt.expire"))
+		}
+	}
+	if c.JWT.Expire.Duration <= 0 {
+		errs = append(errs, errors.New("jwt.expire: must be positive"))
+	}
+	if c.JWT.RefreshExpire.Duration <= c.JWT.Expire.Duration {
+		errs = append(errs, errors.New("jwt.refresh_expire: must be longer than jwt.expire"))
+	}
+	if c.SIWE.Domain == "" {
+		errs = append(errs, errors.New("siwe.domain: must not be empty"))
+	}
+	if c.SIWE.NonceExpire.Duration <= 0 {
+		errs = append(errs, errors.New("siwe.nonce_expire: must be positive"))
+	}
+	switch c.Upload.Backend {
+	case "local":
+		if c.Upload.Dir == "" {
+			errs = append(errs, errors.New("upload.dir: must not be empty with local backend"))
+		}
+	case "s3":
+		if c.Upload.S3.Endpoint == "" || c.Upload.S3.Bucket == "" {
+			errs = append(errs, errors.New("upload.s3: endpoint and bucket must not be empty with s3 backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("upload.backend: must be local or s3, got %q", c.Upload.Backend))
+	}
+	if c.Upload.MaxSize <= 0 || c.Upload.ThumbSize <= 0 {
+		errs = append(errs, errors.New("upload: max_size and thumb_size must be positive"))
+	}
+	if c.Feed.PrecomputeThreshold < 0 {
+		errs = append(errs, errors.New("feed.precompute_threshold: must not be negative"))
+	}
+	switch c.Mail.Backend {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" || c.Mail.SMTP.Port <= 0 {
+			errs = append(errs, errors.New("mail.smtp: host and port must be set with smtp backend"))
+		}
+	case "file":
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("mail.dir: must not be empty with file backend"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("mail.backend: must be smtp, file or memory, got %q", c.Mail.Backend))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from: %w", err))
+	}
+	if c.Mail.VerifyExpire.Duration <= 0 || c.Mail.ResetExpire.Duration <= 0 {
+		errs = append(errs, errors.New("mail: verify_expire and reset_expire must be positive"))
+	}
+	if c.Mail.ResetLimit < 0 || c.Mail.ResetIPLimit < 0 || c.Mail.ResetWindow.Duration <= 0 {
+		errs = append(errs, errors.New("mail: reset_limit and reset_ip_limit must not be negative, reset_window must be positive"))
+	}
+	if c.Login.MaxFailures < 0 || c.Login.IPMaxFailures < 0 {
+		errs = append(errs, errors.New("login: max_failures and ip_max_failures must not be negative"))
+	}
+	if c.Login.FailureWindow.Duration <= 0 || c.Login.LockoutBase.Duration <= 0 || c.Login.AuditRetention.Duration <= 0 || c.Login.ChallengeExpire.Duration <= 0 {
+		errs = append(errs, errors.New("login: failure_window, lockout_base, audit_retention and challenge_expire must be positive"))
+	}
+	if c.Login.LockoutMax.Duration < c.Login.LockoutBase.Duration {
+		errs = append(errs, errors.New("login.lockout_max: must not be shorter than login.lockout_base"))
+	}
+	if c.OIDC.StateExpire.Duration <= 0 {
+		errs = append(errs, errors.New("oidc.state_expire: must be positive"))
+	}
+	if c.OIDC.Mock && c.Env != "dev" {
+		errs = append(errs, errors.New("oidc.mock: only allowed with env dev"))
+	}
+	names := map[string]bool{}
+	for i, p := range c.OIDC.Providers {
+		if !providerName.MatchString(p.Name) || names[p.Name] || (c.OIDC.Mock && p.Name == mockOIDCName) {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d].name: must be unique lowercase letters, digits or '-', got %q", i, p.Name))
+		}
+		names[p.Name] = true
+		if u, err := url.Parse(p.Issuer); err != nil || u.Host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && c.Env == "dev")) {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d].issuer: must be an absolute https url, got %q", i, p.Issuer))
+		}
+		if p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("oidc.providers[%d].client_id: must not be empty", i))
+		}
+	}
+	if c.Log.Filename == "" {
+		errs = append(errs, errors.New("log.filename: must not be empty"))
+	}
+	if c.Log.MaxSize <= 0 || c.Log.MaxBackups < 0 || c.Log.MaxAge < 0 {
+		errs = append(errs, errors.New("log: max_size must be positive, max_backups and max_age must not be negative"))
+	}
+	return errors.Join(errs...)
+}
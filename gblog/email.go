@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 邮件中一次性令牌的用途
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeTwoFactor     = "two_factor" // 登录第二步的 challenge token, 不通过邮件发送
+)
+
+// 与 access token 使用不同的签发者, 互相不能替代
+const actionIssuer = "gblog/action"
+
+// 令牌无效, 过期或已使用, 对外统一返回同一个错误
+var ErrActionToken = errors.New("token is invalid or expired")
+
+// 邮件验证, 重置密码和两步验证令牌, 用 JWT 签名密钥签名, 使用后记录 jti 防止重复使用
+type ActionClaims struct {
+	UserID uint `json:"user_id"`
+	// 邮箱(及重置密码和两步验证时的密码哈希, TOTP 密钥)的摘要, 变化后之前的令牌失效
+	Stamp string `json:"stamp"`
+	jwt.RegisteredClaims
+}
+
+func actionStamp(user *User, purpose string) string {
+	src := purpose + "\x00" + user.Email
+	switch purpose {
+	case PurposeResetPassword:
+		src += "\x00" + user.Password
+	case PurposeTwoFactor:
+		src += "\x00" + user.Password + "\x00" + user.TOTPSecret
+	}
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newActionToken(user *User, purpose string, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return keys.Sign(&ActionClaims{
+		UserID: user.ID,
+		Stamp:  actionStamp(user, purpose),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    actionIssuer,
+			Audience:  jwt.ClaimStrings{purpose},
+			ID:        jti,
+		},
+	})
+}
+
+// 校验令牌但不消耗, 返回对应的用户
+func parseActionToken(raw, purpose string) (*ActionClaims, *User, error) {
+	claims := &ActionClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, keys.Keyfunc,
+		jwt.WithValidMethods(keys.ValidMethods()), jwt.WithIssuer(actionIssuer),
+		jwt.WithAudience(purpose), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, nil, ErrActionToken
+	}
+	user, err := store.Users.GetByID(claims.UserID)
+	if err != nil || actionStamp(user, purpose) != claims.Stamp {
+		return nil, nil, ErrActionToken
+	}
+	return claims, user, nil
+}
+
+// 记录令牌已使用, 已使用过时返回 ErrActionToken
+func spendActionToken(claims *ActionClaims) error {
+	first, err := store.Tokens.ConsumeJTI(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrActionToken
+	}
+	return nil
+}
+
+// 校验并消耗令牌, 成功时返回对应的用户
+func consumeActionToken(raw, purpose string) (*User, error) {
+	claims, user, err := parseActionToken(raw, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := spendActionToken(claims); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 后台发送邮件, 失败只记录日志; 不阻塞请求, 也避免响应时间暴露用户是否存在
+func sendMail(m *Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.Send(ctx, m); err != nil {
+			zap.L().Error("send mail failed", zap.String("subject", m.Subject), zap.Error(err))
+		}
+	}()
+}
+
+// 邮件中的有效期, 如 "24 小时", "30 分钟"
+func expireText(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d 小时", d/time.Hour)
+	}
+	return fmt.Sprintf("%d 分钟", d/time.Minute)
+}
+
+func siteLink(path, token string) string {
+	return strings.TrimRight(cfg.Site.URL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// 向用户当前邮箱发送验证邮件
+func sendVerificationMail(user *User) error {
+	token, err := newActionToken(user, PurposeVerifyEmail, cfg.Mail.VerifyExpire.Duration)
+	if err != nil {
+		return err
+	}
+	sendMail(&Mail{
+		To:      user.Email,
+		Subject: cfg.Site.Title + " 邮箱验证",
+		Body: fmt.Sprintf("%s, 你好:\n\n请打开以下链接验证你的邮箱, 链接 %s 内有效:\n\n%s\n\n如果不是你本人操作, 请忽略这封邮件.\n",
+			user.Username, expireText(cfg.Mail.VerifyExpire.Duration), siteLink("/verify-email", token)),
+	})
+	return nil
+}
+
+func sendResetMail(user *User) error {
+	token, err := newActionToken(user, PurposeResetPassword, cfg.Mail.ResetExpire.Duration)
+	if err != nil {
+		return err
+	}
+	sendMail(&Mail{
+		To:      user.Email,
+		Subject: cfg.Site.Title + " 重置密码",
+		Body: fmt.Sprintf("%s, 你好:\n\n请打开以下链接重置密码, 链接 %s 内有效且只能使用一次:\n\n%s\n\n如果不是你本人操作, 请忽略这封邮件, 你的密码不会改变.\n",
+			user.Username, expireText(cfg.Mail.ResetExpire.Duration), siteLink("/reset-password", token)),
+	})
+	return nil
+}
+
+// POST /auth/me/email/verify 重新发送验证邮件
+func ResendVerificationHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is not set"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is already verified"})
+		return
+	}
+	if err := sendVerificationMail(user); err != nil {
+		zap.L().Error("ResendVerification failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "send mail failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// POST /email/verify 提交邮件中的 token 完成验证
+func VerifyEmailHandler(c *gin.Context) {
+	user, err := consumeActionToken(c.PostForm("token"), PurposeVerifyEmail)
+	if err == nil {
+		err = store.Users.Update(user, map[string]interface{}{"EmailVerified": true})
+	}
+	if errors.Is(err, ErrActionToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.L().Error("VerifyEmail failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("VerifyEmail successfully", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true, "email": user.Email})
+}
+
+// 重置密码请求的计数复用 LoginThrottle, 与登录失败计数的 subject 分开
+func resetSubjects(email, ip string) []string {
+	return []string{"reset:" + strings.ToLower(email), "reset-ip:" + ip}
+}
+
+// 同一邮箱或 IP 请求过多时返回 429, 已写入响应
+func throttleResetRequest(c *gin.Context, email string) bool {
+	now := time.Now()
+	window := cfg.Mail.ResetWindow.Duration
+	subjects := resetSubjects(email, c.ClientIP())
+	until, err := store.Logins.LockedUntil(subjects)
+	if err != nil {
+		zap.L().Error("ForgotPassword failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "request failed"})
+		return true
+	}
+	if until.After(now) {
+		retry := int(until.Sub(now).Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(retry))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests, try again later", "retry_after": retry})
+		return true
+	}
+	// 达到次数的这次请求仍然处理, 之后的请求在 reset_window 内被拒绝
+	limits := []int{cfg.Mail.ResetLimit, cfg.Mail.ResetIPLimit}
+	for i, subject := range subjects {
+		if limits[i] == 0 {
+			continue
+		}
+		n, err := store.Logins.Fail(subject, now, now.Add(-window))
+		if err == nil && n >= limits[i] {
+			err = store.Logins.Lock(subject, now.Add(window))
+		}
+		if err != nil {
+			zap.L().Error("ForgotPassword failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "request failed"})
+			return true
+		}
+	}
+	return false
+}
+
+// 查询账号并发送重置邮件, 在后台执行
+func sendResetMails(email string) {
+	users, err := store.Users.ListByEmail(email)
+	if err != nil {
+		zap.L().Error("ForgotPassword failed", zap.String("error", err.Error()))
+		return
+	}
+	for i := range users {
+		if err := sendResetMail(&users[i]); err != nil {
+			zap.L().Error("ForgotPassword failed", zap.String("error", err.Error()))
+		}
+	}
+	zap.L().Info("ForgotPassword requested", zap.Int("accounts", len(users)))
+}
+
+// POST /password/forgot 提交 email, 向使用该邮箱的账号发送重置邮件
+// 无论邮箱是否存在都返回成功, 查询和发送在后台进行, 响应时间也不暴露注册信息
+func ForgotPasswordHandler(c *gin.Context) {
+	email := c.PostForm("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is null"})
+		return
+	}
+	if throttleResetRequest(c, email) {
+		return
+	}
+	go sendResetMails(email)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// POST /password/reset 提交 token 和 new_password 重置密码, 成功后所有 refresh token 失效
+func ResetPasswordHandler(c *gin.Context) {
+	newPassword := c.PostForm("new_password")
+	if len(newPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new_password must be at least 8 characters"})
+		return
+	}
+	user, err := consumeActionToken(c.PostForm("token"), PurposeResetPassword)
+	if errors.Is(err, ErrActionToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var hashed []byte
+	if err == nil {
+		hashed, err = bcrypt.GenerateFromPassword([]byte(newPassword), 10)
+	}
+	if err == nil {
+		// 能收到邮件说明邮箱属于本人, 一并标记为已验证
+		err = store.Users.Update(user, map[string]interface{}{"Password": string(hashed), "EmailVerified": true})
+	}
+	if err == nil {
+		err = store.Tokens.RevokeUserRefreshTokens(user.ID)
+	}
+	if err != nil {
+		zap.L().Error("ResetPassword failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("ResetPassword successfully", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newMailTestServer(t *testing.T) http.Handler {
+	return newTestServer(t, func(c *Config) { c.Mail.Backend = "memory" })
+}
+
+var mailToken = regexp.MustCompile(`token=(\S+)`)
+
+// 等待后台发送的邮件, 返回第 n 封(从 1 开始)中的 token
+func waitMailToken(t *testing.T, n int) string {
+	t.Helper()
+	mm := mailer.(*MemoryMailer)
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sent := mm.Sent(); len(sent) >= n {
+			m := mailToken.FindStringSubmatch(sent[n-1].Body)
+			if m == nil {
+				t.Fatalf("mail without token: %q", sent[n-1].Body)
+			}
+			token, _ := url.QueryUnescape(m[1])
+			return token
+		}
+	}
+	t.Fatalf("mail %d was not sent", n)
+	return ""
+}
+
+func TestResetTokenIsSingleUse(t *testing.T) {
+	r := newMailTestServer(t)
+	if w, data := doRequest(t, r, http.MethodPost, "/register", "", map[string]string{"username": "alice", "password": "password123", "email": "alice@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("register: status %d: %v", w.Code, data)
+	}
+	// 注册时发送的验证邮件
+	waitMailToken(t, 1)
+
+	if w, _ := doRequest(t, r, http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("forgot: status %d", w.Code)
+	}
+	token := waitMailToken(t, 2)
+
+	reset := func(token, password string) int {
+		w, _ := doRequest(t, r, http.MethodPost, "/password/reset", "", map[string]string{"token": token, "new_password": password})
+		return w.Code
+	}
+	// 重置密码的令牌不能用于验证邮箱
+	if w, _ := doRequest(t, r, http.MethodPost, "/email/verify", "", map[string]string{"token": token}); w.Code != http.StatusBadRequest {
+		t.Errorf("reset token on /email/verify: status %d, want 400", w.Code)
+	}
+	if status := reset(token, "newpassword1"); status != http.StatusOK {
+		t.Fatalf("reset: status %d", status)
+	}
+	if status := reset(token, "newpassword2"); status != http.StatusBadRequest {
+		t.Errorf("reused reset token: status %d, want 400", status)
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "newpassword1"}); w.Code != http.StatusOK {
+		t.Errorf("login with reset password: status %d", w.Code)
+	}
+}
+
+func TestActionTokenBoundToStamp(t *testing.T) {
+	r := newMailTestServer(t)
+	token := registerTestUser(t, r, "alice")
+	user, err := store.Users.GetByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Users.Update(user, map[string]interface{}{"Email": "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	resetToken, _ := newActionToken(user, PurposeResetPassword, time.Hour)
+	verifyToken, _ := newActionToken(user, PurposeVerifyEmail, time.Hour)
+
+	// 修改密码后之前发出的重置令牌失效
+	if w, data := doRequest(t, r, http.MethodPut, "/auth/me/password", token, map[string]string{"old_password": "password123", "new_password": "password456"}); w.Code != http.StatusOK {
+		t.Fatalf("change password: status %d: %v", w.Code, data)
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/password/reset", "", map[string]string{"token": resetToken, "new_password": "password789"}); w.Code != http.StatusBadRequest {
+		t.Errorf("reset token after password change: status %d, want 400", w.Code)
+	}
+
+	// 修改邮箱后之前的验证令牌失效
+	if err := store.Users.Update(user, map[string]interface{}{"Email": "alice@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/email/verify", "", map[string]string{"token": verifyToken}); w.Code != http.StatusBadRequest {
+		t.Errorf("verify token after email change: status %d, want 400", w.Code)
+	}
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+	r := newMailTestServer(t)
+	for i := 0; i < cfg.Mail.ResetLimit; i++ {
+		if w, _ := doRequest(t, r, http.MethodPost, "/password/forgot", "", map[string]string{"email": "nobody@example.com"}); w.Code != http.StatusOK {
+			t.Fatalf("forgot %d: status %d", i+1, w.Code)
+		}
+	}
+	// 不区分邮箱大小写, 未注册的邮箱同样计数
+	w, _ := doRequest(t, r, http.MethodPost, "/password/forgot", "", map[string]string{"email": "Nobody@example.com"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("forgot over limit: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/password/forgot", "", map[string]string{"email": "other@example.com"}); w.Code != http.StatusOK {
+		t.Errorf("forgot for another email: status %d, want 200", w.Code)
+	}
+}
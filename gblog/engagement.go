@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 点赞和表情回应的对象类型
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+)
+
+// 点赞, 每个用户对同一对象只能点赞一次
+type Like struct {
+	ID         uint   `gorm:"primarykey"`
+	UserID     uint   `gorm:"uniqueIndex:idx_like"`
+	TargetType string `gorm:"size:16;uniqueIndex:idx_like"`
+	TargetID   uint   `gorm:"uniqueIndex:idx_like"`
+	CreatedAt  time.Time
+}
+
+// 表情回应, 每个用户对同一对象的每种表情只能回应一次
+type Reaction struct {
+	ID         uint   `gorm:"primarykey"`
+	UserID     uint   `gorm:"uniqueIndex:idx_reaction"`
+	TargetType string `gorm:"size:16;uniqueIndex:idx_reaction"`
+	TargetID   uint   `gorm:"uniqueIndex:idx_reaction"`
+	Emoji      string `gorm:"size:16;uniqueIndex:idx_reaction"`
+	CreatedAt  time.Time
+}
+
+// 各对象每种表情的回应数, 和 Post/Comment 的计数字段一样在增删时同步更新
+type ReactionCount struct {
+	ID         uint   `gorm:"primarykey"`
+	TargetType string `gorm:"size:16;uniqueIndex:idx_reaction_count"`
+	TargetID   uint   `gorm:"uniqueIndex:idx_reaction_count"`
+	Emoji      string `gorm:"size:16;uniqueIndex:idx_reaction_count"`
+	Count      int64
+}
+
+// 收藏
+type Bookmark struct {
+	ID        uint `gorm:"primarykey"`
+	UserID    uint `gorm:"uniqueIndex:idx_bookmark"`
+	PostID    uint `gorm:"uniqueIndex:idx_bookmark;index"`
+	Post      Post
+	CreatedAt time.Time
+}
+
+// 支持的表情
+var reactionEmojis = map[string]bool{
+	"thumbs_up":   true,
+	"thumbs_down": true,
+	"heart":       true,
+	"laugh":       true,
+	"hooray":      true,
+	"confused":    true,
+	"rocket":      true,
+	"eyes":        true,
+}
+
+func reactionsJSON(counts []ReactionCount) map[string]int64 {
+	out := make(map[string]int64, len(counts))
+	for _, rc := range counts {
+		if rc.Count > 0 {
+			out[rc.Emoji] = rc.Count
+		}
+	}
+	return out
+}
+
+// 解析路径中的文章或评论 id 并检查查看权限, 失败时已写入响应
+func resolveTarget(c *gin.Context, kind string) (uint, bool) {
+	if kind == TargetPost {
+		post, ok := getVisiblePost(c)
+		if !ok {
+			return 0, false
+		}
+		return post.ID, true
+	}
+	cid, ok := validateCommentID(c)
+	if !ok {
+		return 0, false
+	}
+	comment, err := store.Comments.GetByID(cid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get comment"})
+		return 0, false
+	}
+	if post, err := store.Posts.GetByID(comment.PostID); err != nil || !canViewPost(c, post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get comment"})
+		return 0, false
+	}
+	return comment.ID, true
+}
+
+// PUT/DELETE /auth/post/:id/like, /auth/comment/:id/like 点赞和取消点赞, 重复请求不改变结果
+func LikeHandler(kind string, like bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return
+		}
+		id, ok := resolveTarget(c, kind)
+		if !ok {
+			return
+		}
+
+		var count int64
+		var err error
+		if like {
+			count, err = store.Engagement.Like(uid, kind, id)
+		} else {
+			count, err = store.Engagement.Unlike(uid, kind, id)
+		}
+		if err != nil {
+			zap.L().Error("Like failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"type":       kind,
+			"id":         id,
+			"liked":      like,
+			"like_count": count,
+		})
+	}
+}
+
+// PUT/DELETE /auth/post/:id/reactions/:emoji, /auth/comment/:id/reactions/:emoji 表情回应
+func ReactionHandler(kind string, add bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		emoji := c.Param("emoji")
+		if !reactionEmojis[emoji] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "emoji is not supported"})
+			return
+		}
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return
+		}
+		id, ok := resolveTarget(c, kind)
+		if !ok {
+			return
+		}
+
+		var err error
+		if add {
+			err = store.Engagement.React(uid, kind, id, emoji)
+		} else {
+			err = store.Engagement.Unreact(uid, kind, id, emoji)
+		}
+		var counts []ReactionCount
+		if err == nil {
+			counts, err = store.Engagement.ReactionCounts(kind, id)
+		}
+		if err != nil {
+			zap.L().Error("Reaction failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"type":      kind,
+			"id":        id,
+			"emoji":     emoji,
+			"reacted":   add,
+			"reactions": reactionsJSON(counts),
+		})
+	}
+}
+
+// PUT/DELETE /auth/post/:id/bookmark 收藏和取消收藏
+func BookmarkHandler(add bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return
+		}
+		post, ok := getVisiblePost(c)
+		if !ok {
+			return
+		}
+
+		var count int64
+		var err error
+		if add {
+			count, err = store.Engagement.Bookmark(uid, post.ID)
+		} else {
+			count, err = store.Engagement.Unbookmark(uid, post.ID)
+		}
+		if err != nil {
+			zap.L().Error("Bookmark failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"post_id":        post.ID,
+			"bookmarked":     add,
+			"bookmark_count": count,
+		})
+	}
+}
+
+// GET /auth/bookmarks 当前用户的收藏, 最近收藏的在前
+func ListBookmarksHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	page, size := parsePage(c, 20, 100)
+
+	bookmarks, total, err := store.Engagement.ListBookmarks(uid, (page-1)*size, size)
+	if err != nil {
+		zap.L().Error("ListBookmarks failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := make([]gin.H, 0, len(bookmarks))
+	for i := range bookmarks {
+		b := &bookmarks[i]
+		// 收藏后文章被删除或撤回发布时不再展示内容
+		if b.Post.ID == 0 || !canViewPost(c, &b.Post) {
+			list = append(list, gin.H{"post_id": b.PostID, "available": false, "bookmarked": b.CreatedAt.Format("2006-01-02 15:04:05")})
+			continue
+		}
+		item := postSummaryJSON(&b.Post)
+		item["available"] = true
+		item["bookmarked"] = b.CreatedAt.Format("2006-01-02 15:04:05")
+		list = append(list, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"bookmarks": list,
+		"total":     total,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteRemovesEngagementAndNotifications(t *testing.T) {
+	r := newTestServer(t, nil)
+	alice := registerTestUser(t, r, "alice")
+	bob := registerTestUser(t, r, "bob")
+
+	must := func(method, path, token string, form map[string]string) map[string]interface{} {
+		t.Helper()
+		w, data := doRequest(t, r, method, path, token, form)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		return data
+	}
+	id := func(data map[string]interface{}, key string) uint {
+		return uint(data[key].(map[string]interface{})["id"].(float64))
+	}
+
+	post := id(must(http.MethodPost, "/auth/post", alice, map[string]string{"title": "a", "content": "a"}), "post")
+	comment := id(must(http.MethodPost, fmt.Sprintf("/auth/post/%d/comment", post), alice, map[string]string{"content": "hi"}), "comment")
+	must(http.MethodPost, fmt.Sprintf("/auth/post/%d/comment", post), bob, map[string]string{"content": "re", "parent_id": fmt.Sprint(comment)})
+	must(http.MethodPut, fmt.Sprintf("/auth/comment/%d/reactions/heart", comment), bob, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%d/reactions/rocket", post), bob, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%d/bookmark", post), bob, nil)
+	if data := must(http.MethodGet, "/auth/notifications", alice, nil); data["total"] == 0.0 {
+		t.Fatalf("alice has no notifications before delete: %v", data)
+	}
+
+	must(http.MethodDelete, fmt.Sprintf("/auth/comment/%d", comment), alice, nil)
+	if counts, _ := store.Engagement.ReactionCounts(TargetComment, comment); len(counts) != 0 {
+		t.Errorf("reaction counts of deleted comment = %v, want none", counts)
+	}
+	must(http.MethodDelete, fmt.Sprintf("/auth/post/%d", post), alice, nil)
+	if counts, _ := store.Engagement.ReactionCounts(TargetPost, post); len(counts) != 0 {
+		t.Errorf("reaction counts of deleted post = %v, want none", counts)
+	}
+	if data := must(http.MethodGet, "/auth/notifications", alice, nil); data["total"] != 0.0 {
+		t.Errorf("notifications after delete = %v, want 0", data["total"])
+	}
+	if data := must(http.MethodGet, "/auth/bookmarks", bob, nil); data["total"] != 0.0 {
+		t.Errorf("bob's bookmarks after delete = %v, want 0", data["total"])
+	}
+}
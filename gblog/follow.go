@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 关注关系
+type Follow struct {
+	ID         uint `gorm:"primarykey"`
+	FollowerID uint `gorm:"uniqueIndex:idx_follow"`
+	FolloweeID uint `gorm:"uniqueIndex:idx_follow;index"`
+	CreatedAt  time.Time
+}
+
+// 预计算的时间线, 只为关注人数较多的用户维护
+type TimelineEntry struct {
+	ID          uint      `gorm:"primarykey"`
+	UserID      uint      `gorm:"uniqueIndex:idx_timeline;index:idx_timeline_order,priority:1"`
+	PostID      uint      `gorm:"uniqueIndex:idx_timeline"`
+	AuthorID    uint      `gorm:"index"`
+	PublishedAt time.Time `gorm:"index:idx_timeline_order,priority:2"`
+}
+
+// 预计算时间线最多保留的文章数
+const maxTimelineEntries = 1000
+
+// 时间线查询条件, 按发布时间倒序
+type FeedQuery struct {
+	Limit int
+	After *PostCursor
+}
+
+// 关注人数达到阈值的用户使用预计算时间线, 阈值为 0 时全部实时查询
+func usePrecomputedFeed(userID uint) (bool, error) {
+	threshold := cfg.Feed.PrecomputeThreshold
+	if threshold <= 0 {
+		return false, nil
+	}
+	n, err := store.Follows.CountFollowing(userID)
+	return n >= int64(threshold), err
+}
+
+// 文章发布时写入关注者的预计算时间线
+func fanOutPost(post *Post) {
+	if cfg.Feed.PrecomputeThreshold <= 0 || post.Status != PostPublished {
+		return
+	}
+	if err := store.Timelines.FanOut(post, cfg.Feed.PrecomputeThreshold); err != nil {
+		zap.L().Error("fan out post failed", zap.Uint("post_id", post.ID), zap.Error(err))
+	}
+}
+
+// 关注关系变化后维护预计算时间线
+func syncTimeline(userID uint) {
+	precomputed, err := usePrecomputedFeed(userID)
+	if err == nil {
+		if precomputed {
+			err = store.Timelines.Rebuild(userID, maxTimelineEntries)
+		} else {
+			err = store.Timelines.Clear(userID)
+		}
+	}
+	if err != nil {
+		zap.L().Error("sync timeline failed", zap.Uint("user_id", userID), zap.Error(err))
+	}
+}
+
+// PUT/DELETE /auth/users/:id/follow 关注和取消关注, 重复请求不改变结果
+func FollowHandler(follow bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return
+		}
+		target, ok := validateUserID(c)
+		if !ok {
+			return
+		}
+		if target == uid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "can't follow yourself"})
+			return
+		}
+		if _, err := store.Users.GetByID(target); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "can't get user"})
+			return
+		}
+
+		var changed bool
+		var err error
+		if follow {
+			changed, err = store.Follows.Follow(uid, target)
+		} else {
+			changed, err = store.Follows.Unfollow(uid, target)
+		}
+		if err != nil {
+			zap.L().Error("Follow failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if changed {
+			syncTimeline(uid)
+			if follow {
+				notifyFollow(uid, target)
+			}
+			zap.L().Info("Follow successfully", zap.Uint("user_id", uid), zap.Uint("target", target), zap.Bool("follow", follow))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "user_id": target, "following": follow})
+	}
+}
+
+// GET /auth/users/:id/followers, /auth/users/:id/following 粉丝和关注列表
+func FollowListHandler(followers bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := validateUserID(c)
+		if !ok {
+			return
+		}
+		page, size := parsePage(c, 20, 100)
+
+		var users []User
+		var total int64
+		var err error
+		if followers {
+			users, total, err = store.Follows.ListFollowers(id, (page-1)*size, size)
+		} else {
+			users, total, err = store.Follows.ListFollowing(id, (page-1)*size, size)
+		}
+		if err != nil {
+			zap.L().Error("FollowList failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		list := make([]gin.H, 0, len(users))
+		for _, u := range users {
+			list = append(list, gin.H{"id": u.ID, "username": u.Username})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"users":   list,
+			"total":   total,
+		})
+	}
+}
+
+// GET /auth/feed?limit=20&page_token= 关注的人发布的文章, 按发布时间倒序
+func FeedHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	q := &FeedQuery{Limit: 20}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		q.Limit = n
+	}
+	if token := c.Query("page_token"); token != "" {
+		cur, err := decodePostCursor(token)
+		if err != nil || cur.SortBy != "publish_at" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page_token is invalid"})
+			return
+		}
+		q.After = cur
+	}
+
+	precomputed, err := usePrecomputedFeed(uid)
+	var posts []Post
+	if err == nil {
+		if precomputed {
+			posts, err = store.Timelines.List(uid, q)
+			// 阈值调整后可能还没有时间线, 首页为空时重建一次
+			if err == nil && len(posts) == 0 && q.After == nil {
+				if err = store.Timelines.Rebuild(uid, maxTimelineEntries); err == nil {
+					posts, err = store.Timelines.List(uid, q)
+				}
+			}
+		} else {
+			posts, err = store.Timelines.FanIn(uid, q)
+		}
+	}
+	if err != nil {
+		zap.L().Error("Feed failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 多查一条判断是否还有下一页
+	nextToken := ""
+	if len(posts) > q.Limit {
+		posts = posts[:q.Limit]
+		last := posts[len(posts)-1]
+		nextToken = PostCursor{SortBy: "publish_at", Desc: true, Time: *last.PublishAt, ID: last.ID}.Encode()
+	}
+
+	list := make([]gin.H, 0, len(posts))
+	for i := range posts {
+		list = append(list, postSummaryJSON(&posts[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"posts":           list,
+		"next_page_token": nextToken,
+		"precomputed":     precomputed,
+	})
+}
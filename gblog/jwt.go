@@ -9,39 +9,42 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecrect = []byte("gblog.com")
-
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
+	Role     Role   `json:"role"`
 	jwt.RegisteredClaims
 }
 
 // 生成token
-func GenerateToken(userID uint, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func GenerateToken(userID uint, username string, role Role) (string, error) {
+	expirationTime := time.Now().Add(cfg.JWT.Expire.Duration)
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
 
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()), // 签发时间
 			NotBefore: jwt.NewNumericDate(time.Now()), // 生效时间
 			Issuer:    "gblog",                        // 签发者
+			ID:        jti,                            // 用于吊销
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecrect)
+	return keys.Sign(claims)
 }
 
 // 解析验证token
 func ParseToken(tokenString string) (*Claims, error) {
 	// 解析
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecrect, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.Keyfunc,
+		jwt.WithValidMethods(keys.ValidMethods()), jwt.WithIssuer("gblog"))
 	if err != nil {
 		return nil, err
 	}
@@ -69,14 +72,51 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(parts[1], apiKeyPrefix) {
+			if authenticateAPIKey(c, parts[1]) {
+				c.Next()
+			}
+			return
+		}
+
 		claims, err := ParseToken(parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "token is invalid"})
+			c.Abort()
+			return
+		}
+
+		// 检查是否已吊销(退出登录)
+		revoked, err := store.Tokens.IsJTIRevoked(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "check token failed"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token is revoked"})
+			c.Abort()
+			return
+		}
+
+		// 账号注销或被管理员删除后, 其他设备上未过期的 token 也立即失效; 角色以数据库为准, 修改后立即生效
+		user, err := store.Users.GetByID(claims.UserID)
+		if err != nil && err != ErrNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "check token failed"})
+			c.Abort()
+			return
+		}
+		if err == ErrNotFound || accountDeleted(user) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token is revoked"})
+			c.Abort()
+			return
 		}
 
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("tokenExpiresAt", claims.ExpiresAt)
+		c.Set("userID", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("tokenID", claims.ID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
 
 		c.Next()
 	}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// 签名密钥, 私钥以 PKCS#8 DER 格式保存, 多实例共享
+type SigningKey struct {
+	KID        string `gorm:"primaryKey;size:64"`
+	Algorithm  string `gorm:"size:16"`
+	PrivateKey []byte
+	CreatedAt  time.Time
+	ActivateAt time.Time // 之前只在 JWKS 中发布, 不用于签名
+	RotateAt   time.Time // 之后不再用于签名
+	ExpiresAt  time.Time `gorm:"index"` // 之后不再用于验证(RotateAt + 宽限期)
+}
+
+type loadedKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	private    crypto.Signer
+	activateAt time.Time
+	rotateAt   time.Time
+	expiresAt  time.Time
+}
+
+// 管理 jwt 签名密钥: HS256 使用配置中的共享密钥, RS256/EdDSA 使用可轮换的非对称密钥
+type KeyManager struct {
+	mu       sync.RWMutex
+	conf     JWTConfig
+	keys     []*loadedKey // 按轮换时间倒序, 第一个已启用的用于签名
+	secret   []byte
+	reloaded time.Time // 上次因未知 kid 重新加载的时间
+}
+
+var keys *KeyManager
+
+const (
+	jwksMaxAge = 5 * time.Minute // JWKS 的缓存时间
+	// 新密钥提前生成并发布的时间, 保证验证方缓存的 JWKS 过期前已包含新密钥; 多留一个周期给定时检查
+	keyPublishAhead = 2 * jwksMaxAge
+)
+
+func NewKeyManager(conf JWTConfig) (*KeyManager, error) {
+	m := &KeyManager{conf: conf}
+	if conf.Algorithm == "HS256" {
+		m.secret = []byte(conf.Secret)
+		return m, nil
+	}
+	if err := m.refresh(true); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case "HS256":
+		return jwt.SigningMethodHS256, nil
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+}
+
+// 生成新密钥, 从 activate 开始用于签名
+func generateSigningKey(alg string, activate time.Time, rotate, grace time.Duration) (*SigningKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		KID:        kid,
+		Algorithm:  alg,
+		PrivateKey: der,
+		CreatedAt:  time.Now(),
+		ActivateAt: activate,
+		RotateAt:   activate.Add(rotate),
+		ExpiresAt:  activate.Add(rotate + grace),
+	}, nil
+}
+
+func loadKey(k *SigningKey) (*loadedKey, error) {
+	method, err := signingMethod(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s: %w", k.KID, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not a signer", k.KID)
+	}
+	return &loadedKey{kid: k.KID, method: method, private: signer, activateAt: k.ActivateAt, rotateAt: k.RotateAt, expiresAt: k.ExpiresAt}, nil
+}
+
+// 从数据库重新加载密钥; rotate 为 true 时清理已过宽限期的密钥, 并在当前密钥到期前 keyPublishAhead 生成下一个密钥
+func (m *KeyManager) refresh(rotate bool) error {
+	now := time.Now()
+	if rotate {
+		if err := store.Keys.DeleteExpired(now); err != nil {
+			return err
+		}
+	}
+	stored, err := store.Keys.List()
+	if err != nil {
+		return err
+	}
+
+	var latest *SigningKey
+	for i := range stored {
+		if k := &stored[i]; k.Algorithm == m.conf.Algorithm && (latest == nil || k.RotateAt.After(latest.RotateAt)) {
+			latest = k
+		}
+	}
+	if rotate && (latest == nil || !now.Before(latest.RotateAt.Add(-keyPublishAhead))) {
+		// 新密钥在当前密钥停止签名时启用; 没有可用密钥(首次启动, 切换算法或停机超过轮换时间)时立即启用
+		activate := now
+		if latest != nil && latest.RotateAt.After(now) {
+			activate = latest.RotateAt
+		}
+		k, err := generateSigningKey(m.conf.Algorithm, activate, m.conf.KeyRotate.Duration, m.conf.KeyGrace.Duration)
+		if err != nil {
+			return err
+		}
+		if err := store.Keys.Create(k); err != nil {
+			return err
+		}
+		zap.L().Info("jwt signing key created", zap.String("kid", k.KID), zap.String("alg", k.Algorithm), zap.Time("activate_at", k.ActivateAt))
+		stored = append(stored, *k)
+	}
+
+	loaded := make([]*loadedKey, 0, len(stored))
+	for i := range stored {
+		k, err := loadKey(&stored[i])
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, k)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].rotateAt.After(loaded[j].rotateAt) })
+
+	m.mu.Lock()
+	m.keys = loaded
+	m.mu.Unlock()
+	return nil
+}
+
+// 定期检查密钥轮换
+func (m *KeyManager) Run(interval time.Duration) {
+	if m.secret != nil {
+		return
+	}
+	for range time.Tick(interval) {
+		if err := m.refresh(true); err != nil {
+			zap.L().Error("refresh jwt signing keys failed", zap.Error(err))
+		}
+	}
+}
+
+// 签名
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	if m.secret != nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.method.Alg() == m.conf.Algorithm && !k.activateAt.After(now) {
+			token := jwt.NewWithClaims(k.method, claims)
+			token.Header["kid"] = k.kid
+			return token.SignedString(k.private)
+		}
+	}
+	return "", errors.New("no active jwt signing key")
+}
+
+// 用于 jwt.Parse, 按 kid 查找验证公钥
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if m.secret != nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return m.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key := m.lookup(kid, token.Method.Alg()); key != nil {
+		return key, nil
+	}
+
+	// 可能是其他实例刚轮换出的新密钥, 限频重新加载一次
+	m.mu.Lock()
+	reload := time.Since(m.reloaded) > 10*time.Second
+	if reload {
+		m.reloaded = time.Now()
+	}
+	m.mu.Unlock()
+	if reload {
+		if err := m.refresh(false); err != nil {
+			return nil, err
+		}
+		if key := m.lookup(kid, token.Method.Alg()); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (m *KeyManager) lookup(kid, alg string) crypto.PublicKey {
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.kid == kid && k.method.Alg() == alg && now.Before(k.expiresAt) {
+			return k.private.Public()
+		}
+	}
+	return nil
+}
+
+// 允许的签名算法, 包括切换算法前仍在宽限期内的旧密钥
+func (m *KeyManager) ValidMethods() []string {
+	if m.secret != nil {
+		return []string{"HS256"}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	methods := []string{m.conf.Algorithm}
+	for _, k := range m.keys {
+		if alg := k.method.Alg(); alg != m.conf.Algorithm {
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// 公钥集合(RFC 7517), 包括宽限期内的旧密钥和尚未启用的新密钥
+func (m *KeyManager) JWKS() []gin.H {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	jwks := make([]gin.H, 0, len(m.keys))
+	for _, k := range m.keys {
+		jwk := gin.H{"kid": k.kid, "use": "sig", "alg": k.method.Alg()}
+		switch pub := k.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = b64(pub.N.Bytes())
+			jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = b64(pub)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// GET /.well-known/jwks.json
+func jwksHandler(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	c.JSON(http.StatusOK, gin.H{"keys": keys.JWKS()})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 评论直播事件类型
+const (
+	EventCommentCreated = "comment_created"
+	EventCommentUpdated = "comment_updated"
+	EventCommentDeleted = "comment_deleted"
+	// 无法补发断线期间的事件, 客户端需要重新拉取评论列表
+	EventReset = "reset"
+)
+
+const (
+	sseHeartbeat      = 30 * time.Second
+	commentReplaySize = 64               // 每篇文章保留的最近事件数, 用于断线重连补发
+	commentBufferSize = 32               // 每个连接的发送缓冲, 写满时断开该连接
+	commentTopicIdle  = 10 * time.Minute // 没有订阅者的文章超过该时间不再保留最近事件
+)
+
+type commentEvent struct {
+	Seq  uint64
+	Type string
+	Data interface{}
+}
+
+type commentSub struct {
+	ch chan commentEvent
+}
+
+type commentTopic struct {
+	subs       map[*commentSub]struct{}
+	recent     []commentEvent
+	evicted    uint64 // 已经不在 recent 中的最大序号
+	lastActive time.Time
+}
+
+// 按文章分发评论事件的进程内 pub/sub
+// 事件 id 为 "启动标识-序号", 重启后旧 id 无法补发
+type commentHub struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	topics map[uint]*commentTopic
+}
+
+var liveComments = &commentHub{
+	epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	topics: make(map[uint]*commentTopic),
+}
+
+func (h *commentHub) eventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// 调用时需持有锁
+func (h *commentHub) topic(postID uint) *commentTopic {
+	t := h.topics[postID]
+	if t == nil {
+		// 之前的事件已经丢弃, 更早的 id 无法补发
+		t = &commentTopic{subs: make(map[*commentSub]struct{}), evicted: h.seq}
+		h.topics[postID] = t
+	}
+	t.lastActive = time.Now()
+	return t
+}
+
+// 订阅文章的评论事件, 返回 lastEventID 之后需要补发的事件; reset 为 true 表示无法补发
+func (h *commentHub) Subscribe(postID uint, lastEventID string) (sub *commentSub, replay []commentEvent, reset bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.topic(postID)
+	sub = &commentSub{ch: make(chan commentEvent, commentBufferSize)}
+	t.subs[sub] = struct{}{}
+	if lastEventID == "" {
+		return sub, nil, false
+	}
+
+	epoch, s, _ := strings.Cut(lastEventID, "-")
+	last, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || epoch != h.epoch || last < t.evicted {
+		return sub, nil, true
+	}
+	for _, e := range t.recent {
+		if e.Seq > last {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, false
+}
+
+func (h *commentHub) Unsubscribe(postID uint, sub *commentSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t := h.topics[postID]; t != nil {
+		if _, ok := t.subs[sub]; ok {
+			delete(t.subs, sub)
+			close(sub.ch)
+		}
+		t.lastActive = time.Now()
+	}
+}
+
+// 发送不阻塞, 缓冲写满的慢连接直接断开, 由客户端带 Last-Event-ID 重连补发
+func (h *commentHub) Publish(postID uint, typ string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.topic(postID)
+	h.seq++
+	e := commentEvent{Seq: h.seq, Type: typ, Data: data}
+	t.recent = append(t.recent, e)
+	if n := len(t.recent) - commentReplaySize; n > 0 {
+		t.evicted = t.recent[n-1].Seq
+		t.recent = append(t.recent[:0], t.recent[n:]...)
+	}
+	for sub := range t.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(t.subs, sub)
+			close(sub.ch)
+			zap.L().Warn("drop slow comment stream", zap.Uint("post_id", postID))
+		}
+	}
+}
+
+// 定期清理长时间没有订阅者的文章
+func (h *commentHub) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		h.mu.Lock()
+		for id, t := range h.topics {
+			if len(t.subs) == 0 && time.Since(t.lastActive) > commentTopicIdle {
+				delete(h.topics, id)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// EventSource 和 WebSocket 不能设置请求头, 允许通过 access_token 参数传递 JWT
+func tokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+// GET /auth/post/:id/comments/stream 通过 SSE 推送文章的新增, 编辑和删除评论
+// 断线重连时浏览器会带上 Last-Event-ID, 也可以用 last_event_id 参数指定
+func CommentStreamHandler(c *gin.Context) {
+	post, ok := getVisiblePost(c)
+	if !ok {
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, replay, reset := liveComments.Subscribe(post.ID, lastEventID)
+	defer liveComments.Unsubscribe(post.ID, sub)
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", 3000)
+	if reset {
+		c.Render(-1, sse.Event{Event: EventReset, Data: gin.H{"post_id": post.ID}})
+	}
+	for _, e := range replay {
+		c.Render(-1, sse.Event{Id: liveComments.eventID(e.Seq), Event: e.Type, Data: e.Data})
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-sub.ch:
+			if !ok {
+				// 发送跟不上被断开, 客户端重连后补发
+				return false
+			}
+			c.Render(-1, sse.Event{Id: liveComments.eventID(e.Seq), Event: e.Type, Data: e.Data})
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		}
+		return true
+	})
+}
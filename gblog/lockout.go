@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 登录失败的原因, 只记录在审计日志中, 不返回给客户端
+const (
+	LoginUnknownUser = "unknown_user"
+	LoginBadPassword = "bad_password"
+)
+
+// 登录失败的审计记录
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey"`
+	Username  string    `gorm:"size:64;index"`
+	UserID    *uint     `gorm:"index"` // 用户不存在时为空
+	IP        string    `gorm:"size:64;index"`
+	UserAgent string    `gorm:"size:255"`
+	Reason    string    `gorm:"size:16"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// 失败计数, Subject 为 "user:用户名" 或 "ip:地址"
+type LoginThrottle struct {
+	Subject     string `gorm:"primaryKey;size:128"`
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	Blocked     int // 锁定期间被拒绝的请求数, 这些请求不写审计记录
+}
+
+// 对外统一的错误, 不区分用户不存在和密码错误
+const loginFailedMessage = "username or password is not correct"
+
+// 用户不存在时也做一次 bcrypt 比较, 使响应时间一致
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("gblog-dummy-password"), 10)
+	return hash
+})
+
+// 用户名区分大小写, 与 User.Username 的唯一约束一致
+func loginSubjects(username, ip string) []string {
+	return []string{"user:" + username, "ip:" + ip}
+}
+
+// 连续失败 n 次后的锁定时间: 达到阈值后从 lockout_base 开始每次翻倍, 不超过 lockout_max
+func lockoutDuration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	d := cfg.Login.LockoutBase.Duration
+	for i := threshold; i < failures && d < cfg.Login.LockoutMax.Duration; i++ {
+		d *= 2
+	}
+	return min(d, cfg.Login.LockoutMax.Duration)
+}
+
+// 记录失败的登录, 累加账号和 IP 的失败次数
+func recordLoginFailure(c *gin.Context, username string, user *User, reason string) {
+	attempt := &LoginAttempt{
+		Username:  username,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Reason:    reason,
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+	if len(attempt.Username) > 64 {
+		attempt.Username = attempt.Username[:64]
+	}
+	if len(attempt.UserAgent) > 255 {
+		attempt.UserAgent = attempt.UserAgent[:255]
+	}
+	if err := store.Logins.RecordAttempt(attempt); err != nil {
+		zap.L().Error("record login attempt failed", zap.Error(err))
+	}
+
+	now := time.Now()
+	thresholds := []int{cfg.Login.MaxFailures, cfg.Login.IPMaxFailures}
+	for i, subject := range loginSubjects(username, attempt.IP) {
+		failures, err := store.Logins.Fail(subject, now, now.Add(-cfg.Login.FailureWindow.Duration))
+		if err == nil {
+			if d := lockoutDuration(failures, thresholds[i]); d > 0 {
+				err = store.Logins.Lock(subject, now.Add(d))
+			}
+		}
+		if err != nil {
+			zap.L().Error("record login failure failed", zap.String("subject", subject), zap.Error(err))
+		}
+	}
+	zap.L().Warn("login failed", zap.String("username", username), zap.String("ip", attempt.IP), zap.String("reason", reason))
+}
+
+// 账号或 IP 处于锁定期时返回 429, 已写入响应
+func checkLoginLocked(c *gin.Context, username string) bool {
+	now := time.Now()
+	subjects := loginSubjects(username, c.ClientIP())
+	until, err := store.Logins.LockedUntil(subjects)
+	if err != nil {
+		zap.L().Error("login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return true
+	}
+	if !until.After(now) {
+		return false
+	}
+	// 锁定期间的请求只计数, 不写审计记录, 也不延长锁定
+	if err := store.Logins.Block(subjects, now); err != nil {
+		zap.L().Error("count blocked login failed", zap.String("username", username), zap.Error(err))
+	}
+	retry := int(until.Sub(now).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retry))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later", "retry_after": retry})
+	return true
+}
+
+// 登录成功后清除账号的失败计数; IP 的计数保留, 避免用自己的账号登录来重置
+func resetLoginFailures(username string) {
+	if err := store.Logins.Reset(loginSubjects(username, "")[0]); err != nil {
+		zap.L().Error("reset login failures failed", zap.String("username", username), zap.Error(err))
+	}
+}
+
+// GET /auth/admin/login-attempts?username=&ip=&page=1&size=20 登录失败记录, 新的在前
+func ListLoginAttemptsHandler(c *gin.Context) {
+	page, size := parsePage(c, 20, 100)
+
+	list, total, err := store.Logins.ListAttempts(c.Query("username"), c.Query("ip"), (page-1)*size, size)
+	if err != nil {
+		zap.L().Error("ListLoginAttempts failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]gin.H, 0, len(list))
+	for _, a := range list {
+		items = append(items, gin.H{
+			"id":         a.ID,
+			"username":   a.Username,
+			"user_id":    a.UserID,
+			"ip":         a.IP,
+			"user_agent": a.UserAgent,
+			"reason":     a.Reason,
+			"created":    a.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"attempts": items,
+		"total":    total,
+	})
+}
+
+// 定期清理过期的审计记录和失败计数
+func purgeLoginRecords(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		// 重置密码请求的计数也保存在 LoginThrottle 中
+		idle := max(cfg.Login.FailureWindow.Duration+cfg.Login.LockoutMax.Duration, cfg.Mail.ResetWindow.Duration)
+		if err := store.Logins.Purge(now.Add(-cfg.Login.AuditRetention.Duration), now.Add(-idle)); err != nil {
+			zap.L().Error("purge login records failed", zap.Error(err))
+		}
+	}
+}
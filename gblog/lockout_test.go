@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	cfg = defaultConfig()
+	cfg.Login.LockoutBase = Duration{time.Minute}
+	cfg.Login.LockoutMax = Duration{5 * time.Minute}
+	for failures, want := range map[int]time.Duration{
+		4: 0,
+		5: time.Minute,
+		6: 2 * time.Minute,
+		7: 4 * time.Minute,
+		8: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := lockoutDuration(failures, 5); got != want {
+			t.Errorf("lockoutDuration(%d, 5) = %v, want %v", failures, got, want)
+		}
+	}
+	if got := lockoutDuration(100, 0); got != 0 {
+		t.Errorf("lockoutDuration with threshold 0 = %v, want 0", got)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	r := newTestServer(t, nil)
+	registerTestUser(t, r, "alice")
+	login := func(username, password string) *http.Response {
+		w, _ := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": username, "password": password})
+		return w.Result()
+	}
+
+	for i := 0; i < cfg.Login.MaxFailures; i++ {
+		if res := login("alice", "wrong"); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status %d, want 401", i+1, res.StatusCode)
+		}
+	}
+	// 达到阈值后正确的密码也被拒绝
+	res := login("alice", "password123")
+	retry, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+	if res.StatusCode != http.StatusTooManyRequests || retry <= 0 || retry > int(cfg.Login.LockoutBase.Seconds())+1 {
+		t.Fatalf("locked login: status %d, Retry-After %q", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	login("alice", "password123")
+
+	// 锁定期间的请求只计数, 不写审计记录
+	_, total, err := store.Logins.ListAttempts("alice", "", 0, 100)
+	if err != nil || total != int64(cfg.Login.MaxFailures) {
+		t.Errorf("audit records = %d, %v, want %d", total, err, cfg.Login.MaxFailures)
+	}
+	var throttle LoginThrottle
+	if err := store.Logins.(*gormLoginRepo).db.First(&throttle, "subject = ?", "user:alice").Error; err != nil || throttle.Blocked != 2 {
+		t.Errorf("blocked count = %d, %v, want 2", throttle.Blocked, err)
+	}
+
+	// 用户名区分大小写, 其他账号不受影响
+	if res := login("Alice", "password123"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login as Alice: status %d, want 401", res.StatusCode)
+	}
+	registerTestUser(t, r, "bob")
+	if res := login("bob", "password123"); res.StatusCode != http.StatusOK {
+		t.Errorf("login of another user: status %d, want 200", res.StatusCode)
+	}
+}
+
+func TestLoginLockoutByIP(t *testing.T) {
+	r := newTestServer(t, func(c *Config) { c.Login.IPMaxFailures = 3 })
+	registerTestUser(t, r, "alice")
+
+	// 同一 IP 尝试不同的用户名
+	for _, username := range []string{"a", "b", "c"} {
+		if w, _ := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": username, "password": "wrong"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("login %s: status %d, want 401", username, w.Code)
+		}
+	}
+	w, _ := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login from locked IP: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -12,7 +16,7 @@ import (
 var logger *zap.Logger
 
 // 初始化日志配置
-func InitLogger(env string) {
+func InitLogger(env string, conf LogConfig) {
 	var core zapcore.Core
 
 	// 日志输出格式：JSON（生产）或控制台（开发）
@@ -47,7 +51,7 @@ func InitLogger(env string) {
 	}
 
 	// 输出目标：控制台 + 文件（按大小/时间切割）
-	writeSyncer := getLogWriter()
+	writeSyncer := getLogWriter(conf)
 	core = zapcore.NewCore(encoder, writeSyncer, level)
 
 	// 开发环境额外开启调用者信息和堆栈跟踪
@@ -66,14 +70,14 @@ func customTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 }
 
 // 日志文件输出配置（自动切割、压缩、清理）
-func getLogWriter() zapcore.WriteSyncer {
+func getLogWriter(conf LogConfig) zapcore.WriteSyncer {
 	// 使用lumberjack实现日志轮转
 	lumberJackLogger := &lumberjack.Logger{
-		Filename:   "./logs/gblog.log", // 日志文件路径
-		MaxSize:    10,                 // 单个文件最大10MB
-		MaxBackups: 30,                 // 最多保留30个备份文件
-		MaxAge:     7,                  // 保留7天
-		Compress:   true,               // 压缩旧日志
+		Filename:   conf.Filename,   // 日志文件路径
+		MaxSize:    conf.MaxSize,    // 单个文件最大MB
+		MaxBackups: conf.MaxBackups, // 最多保留备份文件数
+		MaxAge:     conf.MaxAge,     // 保留天数
+		Compress:   conf.Compress,   // 压缩旧日志
 	}
 
 	// 同时输出到控制台和文件
@@ -82,3 +86,52 @@ func getLogWriter() zapcore.WriteSyncer {
 		zapcore.AddSync(lumberJackLogger), // 文件
 	)
 }
+
+// 请求日志中需要隐藏的查询参数
+var redactedParams = []string{"access_token"}
+
+func redactQuery(path string) string {
+	p, raw, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return p + "?REDACTED"
+	}
+	changed := false
+	for _, k := range redactedParams {
+		if q.Has(k) {
+			q.Set(k, "REDACTED")
+			changed = true
+		}
+	}
+	if !changed {
+		return path
+	}
+	return p + "?" + q.Encode()
+}
+
+// gin 的请求日志, 格式与 gin.Logger() 相同, 但隐藏 URL 中的令牌参数
+func ginLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		var statusColor, methodColor, resetColor string
+		if param.IsOutputColor() {
+			statusColor = param.StatusCodeColor()
+			methodColor = param.MethodColor()
+			resetColor = param.ResetColor()
+		}
+		if param.Latency > time.Minute {
+			param.Latency = param.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			statusColor, param.StatusCode, resetColor,
+			param.Latency,
+			param.ClientIP,
+			methodColor, param.Method, resetColor,
+			redactQuery(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 邮件, 正文为纯文本
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m *Mail) error
+}
+
+var mailer Mailer
+
+func NewMailer(conf MailConfig) (Mailer, error) {
+	switch conf.Backend {
+	case "smtp":
+		return &smtpMailer{conf: conf}, nil
+	case "file":
+		if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+			return nil, err
+		}
+		return &fileMailer{from: conf.From, dir: conf.Dir}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	}
+	return nil, fmt.Errorf("unsupported mail backend %q", conf.Backend)
+}
+
+// 生成 RFC 5322 邮件原文, 正文使用 quoted-printable 编码
+func buildMessage(from string, m *Mail, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(m.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 通过 SMTP 发送, 服务器支持时自动使用 STARTTLS
+type smtpMailer struct {
+	conf MailConfig
+}
+
+func (s *smtpMailer) Send(ctx context.Context, m *Mail) error {
+	msg, err := buildMessage(s.conf.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+	host := s.conf.SMTP.Host
+	var auth smtp.Auth
+	if s.conf.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", s.conf.SMTP.Username, s.conf.SMTP.Password, host)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(s.conf.SMTP.Port))
+	// smtp.SendMail 不支持 context, 在单独的 goroutine 中发送以便超时返回
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(addr, auth, s.conf.From, []string{m.To}, msg) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 写入目录中的 .eml 文件, 用于开发环境
+type fileMailer struct {
+	from string
+	dir  string
+}
+
+func (f *fileMailer) Send(ctx context.Context, m *Mail) error {
+	now := time.Now()
+	msg, err := buildMessage(f.from, m, now)
+	if err != nil {
+		return err
+	}
+	name, err := randomToken(6)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(f.dir, now.Format("20060102-150405-")+name+".eml"), msg, 0o600)
+}
+
+// 保存在内存中, 用于测试
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (mm *MemoryMailer) Send(ctx context.Context, m *Mail) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.sent = append(mm.sent, *m)
+	return nil
+}
+
+// 已发送的邮件
+func (mm *MemoryMailer) Sent() []Mail {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Mail(nil), mm.sent...)
+}
@@ -1,42 +1,186 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
+	"go.uber.org/zap"
 )
 
-// 初始化数据库操作对象
-func initDB() *gorm.DB {
-	dsn := "root:liu123@tcp(127.0.0.1:3306)/gblog?charset=utf8mb4&parseTime=true"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+func main() {
+	// 加载配置
+	var err error
+	cfg, err = LoadConfig(os.Args[1:])
 	if err != nil {
-		panic("Init db failed!")
+		fmt.Fprintf(os.Stderr, "load config failed:\n%v\n", err)
+		os.Exit(2)
 	}
-	db.AutoMigrate(&User{}, &Post{}, &Comment{})
-	return db
-}
 
-var db = initDB()
+	InitLogger(cfg.Env, cfg.Log) // 初始化日志
+	defer logger.Sync()          // 程序退出时刷新缓冲区
 
-func main() {
-	InitLogger("dev")   // 初始化日志
-	defer logger.Sync() // 程序退出时刷新缓冲区
+	// 初始化数据库
+	gdb, err := OpenDB(cfg.DB)
+	if err != nil {
+		zap.L().Fatal("init db failed", zap.String("driver", cfg.DB.Driver), zap.Error(err))
+	}
+	store = NewGormStore(gdb)
+	go purgeExpiredTokens(time.Hour)
+	go purgeLoginRecords(time.Hour)
+	go publishScheduledPosts(time.Minute)
+
+	// 初始化jwt签名密钥
+	keys, err = NewKeyManager(cfg.JWT)
+	if err != nil {
+		zap.L().Fatal("init jwt keys failed", zap.Error(err))
+	}
+	go keys.Run(time.Minute)
+	go liveComments.Run(time.Minute)
+
+	grantAdmins(cfg.Admins)
+
+	// 初始化附件存储
+	if blobs, err = NewBlobStore(cfg.Upload); err != nil {
+		zap.L().Fatal("init blob store failed", zap.Error(err))
+	}
+
+	// 初始化邮件发送
+	if mailer, err = NewMailer(cfg.Mail); err != nil {
+		zap.L().Fatal("init mailer failed", zap.Error(err))
+	}
+
+	// 初始化第三方登录
+	oidcMock, err := initOIDC(cfg.OIDC)
+	if err != nil {
+		zap.L().Fatal("init oidc failed", zap.Error(err))
+	}
 
-	r := gin.Default()
+	// 构建全文搜索索引
+	if err := searchIndex.Rebuild(); err != nil {
+		zap.L().Fatal("build search index failed", zap.Error(err))
+	}
+
+	r, err := newRouter(oidcMock)
+	if err != nil {
+		zap.L().Fatal("set trusted proxies failed", zap.Error(err))
+	}
+	r.Run(cfg.Server.Addr)
+}
+
+// 注册路由; oidcMock 不为空时挂载内置的模拟 OIDC 提供方
+func newRouter(oidcMock http.Handler) (*gin.Engine, error) {
+	// 流式接口允许在 URL 中传递 access_token, 请求日志不能使用 gin.Default() 的 Logger
+	r := gin.New()
+	r.Use(ginLogger(), gin.Recovery())
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+	r.GET("/.well-known/jwks.json", jwksHandler)
 	r.POST("/register", PasswordEncrypt(), registerHandler)
 	r.POST("/login", loginHandler)
+	r.POST("/login/2fa", LoginTwoFactorHandler)
+	r.POST("/token/refresh", refreshTokenHandler)
+	r.POST("/logout", JwtAuthMiddleware(), logoutHandler)
+	r.POST("/email/verify", VerifyEmailHandler)
+	r.POST("/password/forgot", ForgotPasswordHandler)
+	r.POST("/password/reset", ResetPasswordHandler)
+	r.GET("/siwe/nonce", siweNonceHandler)
+	r.GET("/oidc/providers", ListOIDCProvidersHandler)
+	r.GET("/oidc/:provider/login", OIDCLoginHandler)
+	r.GET("/oidc/:provider/callback", OIDCCallbackHandler)
+	if oidcMock != nil {
+		r.Any(mockOIDCPath+"/*path", gin.WrapH(oidcMock))
+	}
+	r.POST("/siwe/verify", siweVerifyHandler)
+	r.GET("/uploads/*key", ServeUploadHandler)
+	r.GET("/feeds/:format", SyndicationHandler(false))
+	r.GET("/users/:id", PublicProfileHandler)
+	r.GET("/users/:id/feeds/:format", SyndicationHandler(true))
 
 	auth := r.Group("/auth")
 	auth.Use(JwtAuthMiddleware())
 
-	auth.POST("/post", CreatePostHandler)
+	auth.POST("/siwe/link", siweLinkHandler)
+	auth.POST("/oidc/:provider/link", OIDCLinkHandler)
+
+	auth.GET("/me", GetMeHandler)
+	auth.PUT("/me", UpdateMeHandler)
+	auth.PUT("/me/password", ChangePasswordHandler)
+	auth.POST("/me/email/verify", ResendVerificationHandler)
+	auth.POST("/me/delete", DeleteMeHandler)
+	auth.GET("/me/2fa", TwoFactorStatusHandler)
+	auth.POST("/me/2fa/setup", SetupTwoFactorHandler)
+	auth.POST("/me/2fa/enable", EnableTwoFactorHandler)
+	auth.POST("/me/2fa/disable", DisableTwoFactorHandler)
+	auth.POST("/me/2fa/recovery-codes", RegenerateRecoveryCodesHandler)
+	auth.GET("/me/api-keys", ListAPIKeysHandler)
+	auth.POST("/me/api-keys", CreateAPIKeyHandler)
+	auth.DELETE("/me/api-keys/:id", RevokeAPIKeyHandler)
+	auth.GET("/me/identities", ListIdentitiesHandler)
+	auth.DELETE("/me/identities/:id", UnlinkIdentityHandler)
+
+	// SSE 推送, 除请求头外也接受 access_token 参数
+	stream := r.Group("/auth", tokenFromQuery(), JwtAuthMiddleware())
+	stream.GET("/notifications/stream", NotificationStreamHandler)
+	stream.GET("/post/:id/comments/stream", CommentStreamHandler)
+
+	auth.GET("/posts", ListPostsHandler)
+	auth.GET("/search", SearchHandler)
+	auth.POST("/markdown/preview", MarkdownPreviewHandler)
+	auth.POST("/upload", RequirePermission(PermPostCreate), UploadHandler)
+	auth.POST("/post/:id/attachments", AttachFilesHandler)
+	auth.DELETE("/attachment/:id", DeleteAttachmentHandler)
+	auth.POST("/post", RequirePermission(PermPostCreate), CreatePostHandler)
 	auth.PUT("/post/:id", UpdatePostHandler)
 	auth.GET("/post/:id", GetPostHandler)
 	auth.DELETE("/post/:id", DeletePostHandler)
 
-	auth.POST("/post/:id/comment", CreateCommentHandler)
+	auth.GET("/post/:id/revisions", ListRevisionsHandler)
+	auth.GET("/post/:id/revisions/diff", DiffRevisionsHandler)
+	auth.POST("/post/:id/revisions/:rev/restore", RestoreRevisionHandler)
+
+	auth.GET("/notifications", ListNotificationsHandler)
+	auth.GET("/notifications/unread_count", UnreadCountHandler)
+	auth.PUT("/notifications/read", MarkReadHandler(true))
+	auth.PUT("/notifications/:id/read", MarkReadHandler(false))
+
+	auth.GET("/feed", FeedHandler)
+	auth.PUT("/users/:id/follow", FollowHandler(true))
+	auth.DELETE("/users/:id/follow", FollowHandler(false))
+	auth.GET("/users/:id/followers", FollowListHandler(true))
+	auth.GET("/users/:id/following", FollowListHandler(false))
+
+	auth.PUT("/post/:id/like", LikeHandler(TargetPost, true))
+	auth.DELETE("/post/:id/like", LikeHandler(TargetPost, false))
+	auth.PUT("/post/:id/reactions/:emoji", ReactionHandler(TargetPost, true))
+	auth.DELETE("/post/:id/reactions/:emoji", ReactionHandler(TargetPost, false))
+	auth.PUT("/post/:id/bookmark", BookmarkHandler(true))
+	auth.DELETE("/post/:id/bookmark", BookmarkHandler(false))
+	auth.GET("/bookmarks", ListBookmarksHandler)
+	auth.PUT("/comment/:id/like", LikeHandler(TargetComment, true))
+	auth.DELETE("/comment/:id/like", LikeHandler(TargetComment, false))
+	auth.PUT("/comment/:id/reactions/:emoji", ReactionHandler(TargetComment, true))
+	auth.DELETE("/comment/:id/reactions/:emoji", ReactionHandler(TargetComment, false))
+
+	auth.POST("/post/:id/tags", AttachTagsHandler)
+	auth.DELETE("/post/:id/tags/:name", DetachTagHandler)
+	auth.GET("/tags", TagCloudHandler)
+	auth.GET("/categories", ListCategoriesHandler)
+	auth.POST("/categories", RequirePermission(PermCategoryManage), CreateCategoryHandler)
+
+	auth.POST("/post/:id/comment", RequirePermission(PermCommentCreate), CreateCommentHandler)
 	auth.GET("/post/:id/comments", GetCommentsByPostID)
+	auth.PUT("/comment/:id", UpdateCommentHandler)
+	auth.DELETE("/comment/:id", DeleteCommentHandler)
+
+	admin := auth.Group("/admin", RequirePermission(PermUserManage))
+	admin.GET("/users", ListUsersHandler)
+	admin.PUT("/users/:id/role", SetUserRoleHandler)
+	admin.DELETE("/users/:id", DeleteUserHandler)
+	admin.GET("/login-attempts", ListLoginAttemptsHandler)
 
-	r.Run(":8080")
+	return r, nil
 }
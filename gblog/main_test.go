@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 使用默认配置和临时 SQLite 数据库初始化全局状态, 返回完整的路由
+func newTestServer(t *testing.T, conf func(c *Config)) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	cfg = defaultConfig()
+	cfg.Site.URL = "http://gblog.test"
+	cfg.DB.DSN = filepath.Join(t.TempDir(), "gblog.db")
+	cfg.Mail.Dir = filepath.Join(t.TempDir(), "mail")
+	cfg.Upload.Dir = filepath.Join(t.TempDir(), "uploads")
+	if conf != nil {
+		conf(cfg)
+	}
+
+	gdb, err := OpenDB(cfg.DB)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	store = NewGormStore(gdb)
+	if keys, err = NewKeyManager(cfg.JWT); err != nil {
+		t.Fatalf("init keys: %v", err)
+	}
+	if mailer, err = NewMailer(cfg.Mail); err != nil {
+		t.Fatalf("init mailer: %v", err)
+	}
+	if blobs, err = NewBlobStore(cfg.Upload); err != nil {
+		t.Fatalf("init blob store: %v", err)
+	}
+	searchIndex = NewSearchIndex()
+	oidcProviders = map[string]*oidcProvider{}
+	oidcMock, err := initOIDC(cfg.OIDC)
+	if err != nil {
+		t.Fatalf("init oidc: %v", err)
+	}
+	r, err := newRouter(oidcMock)
+	if err != nil {
+		t.Fatalf("init router: %v", err)
+	}
+	return r
+}
+
+// 以 multipart 表单发送请求, 返回响应和解析后的 JSON
+func doRequest(t *testing.T, h http.Handler, method, path, token string, form map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	var body bytes.Buffer
+	contentType := ""
+	if form != nil {
+		mw := multipart.NewWriter(&body)
+		for k, v := range form {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+		contentType = mw.FormDataContentType()
+	}
+	req := httptest.NewRequest(method, path, &body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var data map[string]interface{}
+	if w.Header().Get("Content-Type") != "" && bytes.HasPrefix(bytes.TrimSpace(w.Body.Bytes()), []byte("{")) {
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return w, data
+}
+
+func registerTestUser(t *testing.T, h http.Handler, username string) string {
+	t.Helper()
+	w, data := doRequest(t, h, http.MethodPost, "/register", "", map[string]string{"username": username, "password": "password123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d: %s", username, w.Code, w.Body.String())
+	}
+	return data["token"].(string)
+}
+
+func TestRegisterLoginAndPost(t *testing.T) {
+	r := newTestServer(t, nil)
+
+	registerTestUser(t, r, "alice")
+	w, _ := doRequest(t, r, http.MethodPost, "/register", "", map[string]string{"username": "alice", "password": "password123"})
+	if w.Code == http.StatusOK {
+		t.Fatalf("duplicate username registered")
+	}
+
+	w, _ = doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "wrong-password"})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: status %d, want 401", w.Code)
+	}
+	w, data := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	token := data["token"].(string)
+
+	w, data = doRequest(t, r, http.MethodPost, "/auth/post", token, map[string]string{"title": "hello", "content": "**world**", "tags": "go,sqlite"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create post: status %d: %s", w.Code, w.Body.String())
+	}
+	id := uint(data["post"].(map[string]interface{})["id"].(float64))
+
+	post, err := store.Posts.GetByID(id)
+	if err != nil {
+		t.Fatalf("get post from store: %v", err)
+	}
+	if post.Title != "hello" || post.Status != PostPublished {
+		t.Errorf("stored post = %q %q, want hello published", post.Title, post.Status)
+	}
+
+	w, data = doRequest(t, r, http.MethodGet, "/auth/posts?tag=go", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list posts: status %d: %s", w.Code, w.Body.String())
+	}
+	if posts := data["posts"].([]interface{}); len(posts) != 1 {
+		t.Errorf("list posts by tag: got %d posts, want 1", len(posts))
+	}
+
+	w, _ = doRequest(t, r, http.MethodGet, "/auth/posts", "", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("list posts without token: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"go.uber.org/zap"
+)
+
+// 预览内容的最大长度
+const maxPreviewSize = 256 << 10
+
+// Markdown 渲染器, 支持 GFM(表格, 删除线, 任务列表, 自动链接); 原始 HTML 不输出
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// HTML 白名单, 在 UGC 策略的基础上允许代码块的语言标记和任务列表的复选框
+var htmlPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// 将 Markdown 渲染为经过白名单过滤的 HTML
+func renderMarkdown(source string) string {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		// 渲染失败时退化为转义后的原文
+		zap.L().Warn("render markdown failed", zap.Error(err))
+		return "<pre>" + html.EscapeString(source) + "</pre>"
+	}
+	return htmlPolicy.SanitizeReader(&buf).String()
+}
+
+// POST /auth/markdown/preview 编辑器预览
+func MarkdownPreviewHandler(c *gin.Context) {
+	content := c.PostForm("content")
+	if len(content) > maxPreviewSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "content is too large"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"html":    renderMarkdown(content),
+	})
+}
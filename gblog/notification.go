@@ -0,0 +1,295 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 通知类型
+const (
+	NotifyComment = "comment" // 评论了我的文章
+	NotifyReply   = "reply"   // 回复了我的评论
+	NotifyMention = "mention" // 在评论中 @ 了我
+	NotifyFollow  = "follow"  // 关注了我
+)
+
+// 站内通知
+type Notification struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index:idx_notification_user,priority:1"` // 接收者
+	ActorID   uint   // 触发者
+	Actor     User   `gorm:"foreignKey:ActorID"`
+	Type      string `gorm:"size:16"`
+	PostID    *uint
+	CommentID *uint
+	ReadAt    *time.Time `gorm:"index:idx_notification_user,priority:2"`
+	CreatedAt time.Time
+}
+
+// 一条评论最多通知的 @ 用户数
+const maxMentions = 10
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w{1,32})`)
+
+// 提取内容中 @ 的用户名, 去重后最多 maxMentions 个
+func parseMentions(content string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		names = append(names, m[1])
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+func notificationJSON(n *Notification) gin.H {
+	return gin.H{
+		"id":         n.ID,
+		"type":       n.Type,
+		"actor_id":   n.ActorID,
+		"actor":      n.Actor.Username,
+		"post_id":    n.PostID,
+		"comment_id": n.CommentID,
+		"read":       n.ReadAt != nil,
+		"created":    n.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// 已连接客户端的实时推送, 每个用户可以有多个连接
+type notifyHub struct {
+	mu   sync.Mutex
+	subs map[uint]map[chan *Notification]struct{}
+}
+
+var notifier = &notifyHub{subs: make(map[uint]map[chan *Notification]struct{})}
+
+func (h *notifyHub) Subscribe(userID uint) (<-chan *Notification, func()) {
+	ch := make(chan *Notification, 16)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan *Notification]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[userID], ch)
+		if len(h.subs[userID]) == 0 {
+			delete(h.subs, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// 不阻塞发送, 客户端处理不过来时丢弃, 通知已保存, 可以通过列表接口补齐
+func (h *notifyHub) Publish(n *Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// 保存并推送通知, 不通知自己
+func notify(actor *User, list []Notification) {
+	out := make([]Notification, 0, len(list))
+	for _, n := range list {
+		if n.UserID != actor.ID {
+			n.ActorID = actor.ID
+			out = append(out, n)
+		}
+	}
+	if len(out) == 0 {
+		return
+	}
+	if err := store.Notifications.Create(out); err != nil {
+		zap.L().Error("create notifications failed", zap.Uint("actor_id", actor.ID), zap.Error(err))
+		return
+	}
+	for i := range out {
+		out[i].Actor = *actor
+		notifier.Publish(&out[i])
+	}
+}
+
+// 评论产生的通知: 文章作者收到 comment, 被回复者收到 reply, 被 @ 的用户收到 mention; 每人只收到一条
+func notifyComment(post *Post, comment *Comment, parent *Comment) {
+	actor, err := store.Users.GetByID(comment.UserID)
+	if err != nil {
+		zap.L().Error("notify comment failed", zap.Uint("comment_id", comment.ID), zap.Error(err))
+		return
+	}
+	notified := map[uint]bool{actor.ID: true}
+	var list []Notification
+	add := func(userID uint, kind string) {
+		if notified[userID] {
+			return
+		}
+		notified[userID] = true
+		list = append(list, Notification{UserID: userID, Type: kind, PostID: &post.ID, CommentID: &comment.ID})
+	}
+	if parent != nil {
+		add(parent.UserID, NotifyReply)
+	}
+	add(post.UserID, NotifyComment)
+	// 未发布的文章其他人看不到, 不通知被 @ 的用户
+	if names := parseMentions(comment.Content); len(names) > 0 && post.Status == PostPublished {
+		users, err := store.Users.ListByUsernames(names)
+		if err != nil {
+			zap.L().Error("notify comment failed", zap.Uint("comment_id", comment.ID), zap.Error(err))
+		}
+		for _, u := range users {
+			add(u.ID, NotifyMention)
+		}
+	}
+	notify(actor, list)
+}
+
+func notifyFollow(followerID, followeeID uint) {
+	actor, err := store.Users.GetByID(followerID)
+	if err != nil {
+		zap.L().Error("notify follow failed", zap.Uint("user_id", followerID), zap.Error(err))
+		return
+	}
+	notify(actor, []Notification{{UserID: followeeID, Type: NotifyFollow}})
+}
+
+// GET /auth/notifications?unread=true&page=1&size=20 通知列表, 新的在前
+func ListNotificationsHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	page, size := parsePage(c, 20, 100)
+	unreadOnly := c.Query("unread") == "true"
+
+	list, total, err := store.Notifications.List(uid, unreadOnly, (page-1)*size, size)
+	var unread int64
+	if err == nil {
+		unread, err = store.Notifications.CountUnread(uid)
+	}
+	if err != nil {
+		zap.L().Error("ListNotifications failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]gin.H, 0, len(list))
+	for i := range list {
+		items = append(items, notificationJSON(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"notifications": items,
+		"total":         total,
+		"unread":        unread,
+	})
+}
+
+// GET /auth/notifications/unread_count
+func UnreadCountHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	unread, err := store.Notifications.CountUnread(uid)
+	if err != nil {
+		zap.L().Error("UnreadCount failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "unread": unread})
+}
+
+// PUT /auth/notifications/:id/read 标记一条已读; PUT /auth/notifications/read 全部标记已读
+func MarkReadHandler(all bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return
+		}
+		var id uint
+		if !all {
+			n, err := strconv.ParseUint(c.Param("id"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "notification id format is not correct"})
+				return
+			}
+			id = uint(n)
+		}
+
+		var err error
+		if all {
+			err = store.Notifications.MarkAllRead(uid)
+		} else {
+			err = store.Notifications.MarkRead(uid, id)
+		}
+		var unread int64
+		if err == nil {
+			unread, err = store.Notifications.CountUnread(uid)
+		}
+		if err == ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "can't get notification"})
+			return
+		}
+		if err != nil {
+			zap.L().Error("MarkRead failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "unread": unread})
+	}
+}
+
+// GET /auth/notifications/stream 通过 SSE 推送新通知
+// 连接后先发送一次 unread 事件, 之后每条新通知发送 notification 事件, 空闲时定期发送心跳
+func NotificationStreamHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	unread, err := store.Notifications.CountUnread(uid)
+	if err != nil {
+		zap.L().Error("NotificationStream failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ch, unsubscribe := notifier.Subscribe(uid)
+	defer unsubscribe()
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("unread", gin.H{"unread": unread})
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case n := <-ch:
+			c.SSEvent("notification", notificationJSON(n))
+		case <-heartbeat.C:
+			// 注释行, 客户端忽略, 用于保持连接
+			io.WriteString(w, ": ping\n\n")
+		}
+		return true
+	})
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// 登录流程中跳转到提供方之前保存的状态, 回调时按 state 取出并删除
+type OIDCState struct {
+	State     string    `gorm:"primaryKey;size:64"`
+	Provider  string    `gorm:"size:32"`
+	Nonce     string    `gorm:"size:64"`
+	Verifier  string    `gorm:"size:128"` // PKCE code_verifier
+	UserID    *uint     // 绑定账号时为当前用户, 登录时为空
+	Binding   string    `gorm:"size:64"` // 绑定账号时发起请求的浏览器 cookie 的哈希, 回调时校验
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// 绑定账号时写入浏览器的 cookie, 只有发起绑定的浏览器能完成回调, 防止诱导他人打开绑定链接
+const oidcLinkCookie = "gblog_oidc_link"
+
+// 外部账号和用户的对应关系
+type UserIdentity struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index"`
+	Provider  string `gorm:"size:32;uniqueIndex:idx_identity_subject"`
+	Subject   string `gorm:"size:255;uniqueIndex:idx_identity_subject"` // 提供方的 sub, 在提供方内唯一且不变
+	Email     string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+// 提供方的 discovery 文档中用到的字段
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// ID token 中用到的声明
+type oidcClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	AuthorizedParty   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// 公钥集合缓存中没有 ID token 的 kid 时重新获取, 两次获取的最小间隔
+const oidcJWKSRefresh = time.Minute
+
+var ErrOIDC = errors.New("oidc login failed")
+
+type oidcProvider struct {
+	conf   OIDCProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *oidcMetadata
+	keys     map[string]interface{} // kid -> 公钥
+	keysTime time.Time
+}
+
+// 已配置的提供方, key 为名称
+var oidcProviders = map[string]*oidcProvider{}
+
+// 按配置创建提供方, 启用模拟提供方时返回需要挂载的 handler
+func initOIDC(conf OIDCConfig) (http.Handler, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, p := range conf.Providers {
+		oidcProviders[p.Name] = &oidcProvider{conf: p, client: client}
+	}
+	if !conf.Mock {
+		return nil, nil
+	}
+	mock, err := newMockOIDCProvider(strings.TrimRight(cfg.Site.URL, "/")+mockOIDCPath, mockOIDCClientID, mockOIDCClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	oidcProviders[mockOIDCName] = &oidcProvider{
+		conf: OIDCProviderConfig{
+			Name:         mockOIDCName,
+			Issuer:       mock.issuer,
+			ClientID:     mockOIDCClientID,
+			ClientSecret: mockOIDCClientSecret,
+		},
+		// 后端请求直接交给模拟提供方处理, 不经过网络
+		client: &http.Client{Transport: handlerTransport{mock}, Timeout: 10 * time.Second},
+	}
+	return mock, nil
+}
+
+func (p *oidcProvider) redirectURL() string {
+	return strings.TrimRight(cfg.Site.URL, "/") + "/oidc/" + p.conf.Name + "/callback"
+}
+
+func (p *oidcProvider) scopes() string {
+	if len(p.conf.Scopes) == 0 {
+		return "openid email profile"
+	}
+	return strings.Join(p.conf.Scopes, " ")
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discovery 文档, 第一次使用时获取并缓存
+func (p *oidcProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var meta oidcMetadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.conf.Issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.conf.Issuer {
+		return nil, fmt.Errorf("oidc %s: issuer mismatch, got %q", p.conf.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc %s: incomplete discovery document", p.conf.Name)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// 公钥集合中用到的字段
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWK 转为公钥, 支持 RSA, EC(P-256/P-384) 和 Ed25519
+func parseJWK(k *oidcJWK) (interface{}, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := b64(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// 按 kid 查找公钥, 缓存中没有时重新获取公钥集合(提供方轮换密钥)
+func (p *oidcProvider) publicKey(ctx context.Context, kid string) (interface{}, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysTime) < oidcJWKSRefresh {
+		return nil, fmt.Errorf("oidc %s: unknown key id %q", p.conf.Name, kid)
+	}
+	var set struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]interface{}{}
+	p.keysTime = time.Now()
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			zap.L().Warn("skip oidc jwk", zap.String("provider", p.conf.Name), zap.String("kid", k.Kid), zap.Error(err))
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc %s: unknown key id %q", p.conf.Name, kid)
+}
+
+// 生成登录地址并保存 state, nonce 和 PKCE code_verifier; 绑定账号时 binding 为浏览器 cookie 的哈希
+func (p *oidcProvider) authorizationURL(ctx context.Context, userID *uint, binding, loginHint string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(48)
+	if err != nil {
+		return "", err
+	}
+	err = store.OIDC.CreateState(&OIDCState{
+		State:     state,
+		Provider:  p.conf.Name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		UserID:    userID,
+		Binding:   binding,
+		ExpiresAt: time.Now().Add(cfg.OIDC.StateExpire.Duration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.ClientID)
+	q.Set("redirect_uri", p.redirectURL())
+	q.Set("scope", p.scopes())
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if loginHint != "" {
+		q.Set("login_hint", loginHint)
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// 用授权码换取 ID token 并校验签名, issuer, audience 和 nonce
+func (p *oidcProvider) exchange(ctx context.Context, code string, state *OIDCState) (*oidcClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL())
+	form.Set("client_id", p.conf.ClientID)
+	form.Set("code_verifier", state.Verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("oidc %s: decode token response: %w", p.conf.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("oidc %s: token endpoint status %d: %s %s", p.conf.Name, resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+
+	claims := &oidcClaims{}
+	_, err = jwt.ParseWithClaims(body.IDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(p.conf.Issuer), jwt.WithAudience(p.conf.ClientID),
+		jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, fmt.Errorf("oidc %s: invalid id token: %w", p.conf.Name, err)
+	}
+	if claims.Nonce != state.Nonce {
+		return nil, fmt.Errorf("oidc %s: nonce mismatch", p.conf.Name)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.conf.ClientID {
+		return nil, fmt.Errorf("oidc %s: azp mismatch", p.conf.Name)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("oidc %s: id token has no subject", p.conf.Name)
+	}
+	return claims, nil
+}
+
+// 提供方名称, 用于路由和 UserIdentity.Provider
+var providerName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// 为外部账号生成不重复的用户名, 优先使用 preferred_username, 其次邮箱前缀
+func oidcUsername(provider string, claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(base, "-"), "-.")
+	if len(base) > 32 {
+		base = base[:32]
+	}
+	if base == "" || reservedUsername(base) {
+		sum := sha256.Sum256([]byte(claims.Subject))
+		base = provider + "-" + base64.RawURLEncoding.EncodeToString(sum[:6])
+	}
+	name := base
+	for i := 2; i <= 10; i++ {
+		if _, err := store.Users.GetByUsername(name); errors.Is(err, ErrNotFound) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = base + "-" + strconv.Itoa(i)
+	}
+	suffix, err := randomToken(4)
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + strings.ToLower(usernameUnsafe.ReplaceAllString(suffix, "")), nil
+}
+
+// 按外部账号查找用户, 没有绑定时创建新用户; 不按邮箱合并已有账号, 避免被同邮箱的外部账号接管
+func oidcUser(provider string, claims *oidcClaims) (*User, error) {
+	identity, err := store.OIDC.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		return store.Users.GetByID(identity.UserID)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	username, err := oidcUsername(provider, claims)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{Username: username}
+	if claims.Email != "" && validEmail(claims.Email) {
+		user.Email = claims.Email
+		user.EmailVerified = claims.EmailVerified
+	}
+	err = store.OIDC.CreateUserWithIdentity(user, &UserIdentity{Provider: provider, Subject: claims.Subject, Email: claims.Email})
+	return user, err
+}
+
+func getOIDCProvider(c *gin.Context) (*oidcProvider, bool) {
+	p, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc provider not found"})
+		return nil, false
+	}
+	return p, true
+}
+
+// GET /oidc/providers 已配置的登录方式
+func ListOIDCProvidersHandler(c *gin.Context) {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	c.JSON(http.StatusOK, gin.H{"success": true, "providers": names})
+}
+
+// GET /oidc/:provider/login 跳转到提供方登录, 可选参数 login_hint
+func OIDCLoginHandler(c *gin.Context) {
+	p, ok := getOIDCProvider(c)
+	if !ok {
+		return
+	}
+	target, err := p.authorizationURL(c.Request.Context(), nil, "", c.Query("login_hint"))
+	if err != nil {
+		zap.L().Error("oidc login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusBadGateway, gin.H{"error": ErrOIDC.Error()})
+		return
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// 回调是从提供方跳转回来的跨站 GET 请求, SameSite=Lax 的 cookie 会被带上
+func setLinkCookie(c *gin.Context, p *oidcProvider, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLinkCookie, value, maxAge, "/oidc/"+p.conf.Name, "", strings.HasPrefix(cfg.Site.URL, "https://"), true)
+}
+
+// POST /auth/oidc/:provider/link 绑定外部账号, 返回需要在浏览器中打开的 authorization_url
+func OIDCLinkHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	p, ok := getOIDCProvider(c)
+	if !ok {
+		return
+	}
+	binding, err := randomToken(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "link account failed"})
+		return
+	}
+	target, err := p.authorizationURL(c.Request.Context(), &uid, hashToken(binding), c.PostForm("login_hint"))
+	if err != nil {
+		zap.L().Error("oidc link failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusBadGateway, gin.H{"error": ErrOIDC.Error()})
+		return
+	}
+	setLinkCookie(c, p, binding, int(cfg.OIDC.StateExpire.Duration.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"success": true, "authorization_url": target})
+}
+
+// GET /oidc/:provider/callback 提供方登录后的回调, 登录时返回令牌对, 绑定时返回绑定结果
+func OIDCCallbackHandler(c *gin.Context) {
+	p, ok := getOIDCProvider(c)
+	if !ok {
+		return
+	}
+	state, err := store.OIDC.ConsumeState(c.Query("state"), time.Now())
+	if err != nil || state.Provider != p.conf.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state is invalid or expired"})
+		return
+	}
+	if state.UserID != nil {
+		binding, err := c.Cookie(oidcLinkCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(binding)), []byte(state.Binding)) != 1 {
+			zap.L().Warn("oidc link rejected", zap.String("error", "browser binding mismatch"), zap.Uint("userID", *state.UserID), zap.String("provider", p.conf.Name))
+			c.JSON(http.StatusForbidden, gin.H{"error": "link must be completed in the browser that started it"})
+			return
+		}
+	}
+	if e := c.Query("error"); e != "" {
+		zap.L().Warn("oidc login denied", zap.String("provider", p.conf.Name), zap.String("error", e), zap.String("description", c.Query("error_description")))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login was denied by the provider", "reason": e})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is null"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+	claims, err := p.exchange(ctx, code, state)
+	if err != nil {
+		zap.L().Error("oidc login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrOIDC.Error()})
+		return
+	}
+
+	if state.UserID != nil {
+		setLinkCookie(c, p, "", -1)
+		oidcLink(c, p.conf.Name, *state.UserID, claims)
+		return
+	}
+
+	user, err := oidcUser(p.conf.Name, claims)
+	if err != nil {
+		zap.L().Error("oidc login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	zap.L().Info("oidc login successfully", zap.Uint("userID", user.ID), zap.String("provider", p.conf.Name))
+	if user.TOTPEnabled {
+		writeTwoFactorChallenge(c, user)
+		return
+	}
+	writeLoginSuccess(c, user)
+}
+
+func oidcLink(c *gin.Context, provider string, uid uint, claims *oidcClaims) {
+	identity, err := store.OIDC.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		if identity.UserID != uid {
+			c.JSON(http.StatusConflict, gin.H{"error": "account is linked to another user"})
+			return
+		}
+	} else if errors.Is(err, ErrNotFound) {
+		identity = &UserIdentity{UserID: uid, Provider: provider, Subject: claims.Subject, Email: claims.Email}
+		err = store.OIDC.CreateIdentity(identity)
+	}
+	if err != nil {
+		zap.L().Error("oidc link failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "link account failed"})
+		return
+	}
+	zap.L().Info("oidc link successfully", zap.Uint("userID", uid), zap.String("provider", provider))
+	c.JSON(http.StatusOK, gin.H{"success": true, "identity": identityJSON(identity)})
+}
+
+func identityJSON(i *UserIdentity) gin.H {
+	return gin.H{
+		"id":       i.ID,
+		"provider": i.Provider,
+		"subject":  i.Subject,
+		"email":    i.Email,
+		"created":  i.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// GET /auth/me/identities 已绑定的外部账号
+func ListIdentitiesHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	list, err := store.OIDC.ListIdentities(uid)
+	if err != nil {
+		zap.L().Error("ListIdentities failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]gin.H, 0, len(list))
+	for i := range list {
+		items = append(items, identityJSON(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "identities": items})
+}
+
+// DELETE /auth/me/identities/:id 解除绑定, 不能解除最后一种登录方式
+func UnlinkIdentityHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "identity id format is not correct"})
+		return
+	}
+	list, err := store.OIDC.ListIdentities(user.ID)
+	if err != nil {
+		zap.L().Error("UnlinkIdentity failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	found := false
+	for _, i := range list {
+		found = found || i.ID == uint(id)
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+		return
+	}
+	if user.Password == "" && user.WalletAddress == nil && len(list) == 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "set a password before unlinking the last login method"})
+		return
+	}
+	if err := store.OIDC.DeleteIdentity(user.ID, uint(id)); err != nil {
+		zap.L().Error("UnlinkIdentity failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("UnlinkIdentity successfully", zap.Uint("user_id", user.ID), zap.Uint64("identity_id", id))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
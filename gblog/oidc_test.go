@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newOIDCTestServer(t *testing.T) http.Handler {
+	return newTestServer(t, func(c *Config) { c.OIDC.Mock = true })
+}
+
+// 浏览器访问 target, 返回响应; target 可以是 gblog 的绝对地址
+func browse(t *testing.T, h http.Handler, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatalf("parse %s: %v", target, err)
+	}
+	req := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// 走到提供方同意授权为止, 返回提供方跳转回 gblog 的回调地址
+func authorizeAt(t *testing.T, h http.Handler, authorizationURL string) string {
+	t.Helper()
+	w := browse(t, h, authorizationURL)
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize: status %d: %s", w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location")
+}
+
+func startOIDCLogin(t *testing.T, h http.Handler, login string) string {
+	t.Helper()
+	w := browse(t, h, "/oidc/mock/login?login_hint="+login)
+	if w.Code != http.StatusFound {
+		t.Fatalf("oidc login: status %d: %s", w.Code, w.Body.String())
+	}
+	return authorizeAt(t, h, w.Header().Get("Location"))
+}
+
+// 修改回调对应的 state 记录, 模拟 code_verifier 或 nonce 与提供方收到的不一致
+func tamperState(t *testing.T, callback string, modify func(s *OIDCState)) {
+	t.Helper()
+	u, _ := url.Parse(callback)
+	s, err := store.OIDC.ConsumeState(u.Query().Get("state"), time.Now())
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	modify(s)
+	if err := store.OIDC.CreateState(s); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+}
+
+func browseJSON(t *testing.T, h http.Handler, target string, cookies ...*http.Cookie) (int, map[string]interface{}) {
+	t.Helper()
+	w := browse(t, h, target, cookies...)
+	var data map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("GET %s: decode response %q: %v", target, w.Body.String(), err)
+	}
+	return w.Code, data
+}
+
+func TestOIDCLogin(t *testing.T) {
+	r := newOIDCTestServer(t)
+
+	callback := startOIDCLogin(t, r, "alice")
+	status, data := browseJSON(t, r, callback)
+	if status != http.StatusOK || data["token"] == nil {
+		t.Fatalf("callback: status %d: %v", status, data)
+	}
+	user := data["user"].(map[string]interface{})
+	if user["username"] != "alice" {
+		t.Errorf("username = %v, want alice", user["username"])
+	}
+	identity, err := store.OIDC.GetIdentity(mockOIDCName, "mock-alice")
+	if err != nil || identity.UserID != uint(user["id"].(float64)) {
+		t.Fatalf("identity not linked to the new user: %+v, %v", identity, err)
+	}
+
+	// state 只能使用一次
+	if status, _ := browseJSON(t, r, callback); status != http.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want 400", status)
+	}
+
+	// 再次登录使用同一个用户
+	status, data = browseJSON(t, r, startOIDCLogin(t, r, "alice"))
+	if status != http.StatusOK || data["user"].(map[string]interface{})["id"] != user["id"] {
+		t.Errorf("second login: status %d: %v", status, data)
+	}
+
+	// 用户拒绝授权
+	w := browse(t, r, "/oidc/mock/login?login_hint=deny")
+	if status, _ := browseJSON(t, r, authorizeAt(t, r, w.Header().Get("Location"))); status != http.StatusUnauthorized {
+		t.Errorf("denied login: status %d, want 401", status)
+	}
+}
+
+func TestOIDCLoginPKCEMismatch(t *testing.T) {
+	r := newOIDCTestServer(t)
+
+	callback := startOIDCLogin(t, r, "alice")
+	tamperState(t, callback, func(s *OIDCState) { s.Verifier += "x" })
+	if status, data := browseJSON(t, r, callback); status != http.StatusUnauthorized || data["token"] != nil {
+		t.Errorf("callback with wrong code_verifier: status %d: %v", status, data)
+	}
+	if _, err := store.OIDC.GetIdentity(mockOIDCName, "mock-alice"); err != ErrNotFound {
+		t.Errorf("identity created after failed login: %v", err)
+	}
+}
+
+func TestOIDCLoginNonceMismatch(t *testing.T) {
+	r := newOIDCTestServer(t)
+
+	callback := startOIDCLogin(t, r, "alice")
+	tamperState(t, callback, func(s *OIDCState) { s.Nonce = "other-nonce" })
+	if status, data := browseJSON(t, r, callback); status != http.StatusUnauthorized || data["token"] != nil {
+		t.Errorf("callback with wrong nonce: status %d: %v", status, data)
+	}
+}
+
+func TestOIDCLoginTwoFactor(t *testing.T) {
+	r := newOIDCTestServer(t)
+
+	status, data := browseJSON(t, r, startOIDCLogin(t, r, "alice"))
+	if status != http.StatusOK {
+		t.Fatalf("first login: status %d: %v", status, data)
+	}
+	user, err := store.Users.GetByID(uint(data["user"].(map[string]interface{})["id"].(float64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	if err := store.Users.Update(user, map[string]interface{}{"TOTPSecret": secret, "TOTPEnabled": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 开启两步验证后回调只返回 challenge token
+	status, data = browseJSON(t, r, startOIDCLogin(t, r, "alice"))
+	if status != http.StatusOK || data["two_factor_required"] != true || data["token"] != nil {
+		t.Fatalf("login with 2fa: status %d: %v", status, data)
+	}
+	code := totpCode([]byte("12345678901234567890"), time.Now().Unix()/totpPeriod, totpDigits)
+	w, data := doRequest(t, r, http.MethodPost, "/login/2fa", "", map[string]string{"challenge_token": data["challenge_token"].(string), "code": code})
+	if w.Code != http.StatusOK || data["token"] == nil {
+		t.Errorf("login/2fa: status %d: %v", w.Code, data)
+	}
+}
+
+func TestOIDCLinkRequiresInitiatingBrowser(t *testing.T) {
+	r := newOIDCTestServer(t)
+	token := registerTestUser(t, r, "bob")
+
+	link := func() (string, []*http.Cookie) {
+		w, data := doRequest(t, r, http.MethodPost, "/auth/oidc/mock/link", token, map[string]string{"login_hint": "bobby"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("link: status %d: %s", w.Code, w.Body.String())
+		}
+		return authorizeAt(t, r, data["authorization_url"].(string)), w.Result().Cookies()
+	}
+
+	// 链接被发给其他浏览器打开时没有 cookie
+	callback, _ := link()
+	if status, _ := browseJSON(t, r, callback); status != http.StatusForbidden {
+		t.Errorf("link callback without cookie: status %d, want 403", status)
+	}
+
+	callback, cookies := link()
+	status, data := browseJSON(t, r, callback, cookies...)
+	if status != http.StatusOK || data["identity"] == nil {
+		t.Fatalf("link callback: status %d: %v", status, data)
+	}
+	identity, err := store.OIDC.GetIdentity(mockOIDCName, "mock-bobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob, _ := store.Users.GetByUsername("bob"); identity.UserID != bob.ID {
+		t.Errorf("identity linked to user %d, want bob", identity.UserID)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 内置的模拟 OIDC 提供方, 用于开发和测试, 不需要外部网络
+const (
+	mockOIDCName         = "mock"
+	mockOIDCPath         = "/oidc-mock"
+	mockOIDCClientID     = "gblog"
+	mockOIDCClientSecret = "gblog-mock-secret"
+	mockOIDCCodeExpire   = time.Minute
+)
+
+// 授权码对应的登录请求
+type mockAuthCode struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	login       string
+	expiresAt   time.Time
+}
+
+// 实现授权码 + PKCE(S256) 流程; 不显示登录页, 直接以 login_hint 指定的用户(默认 mockuser)同意授权,
+// login_hint 为 deny 时模拟用户拒绝
+type mockOIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	kid          string
+
+	mu    sync.Mutex
+	codes map[string]*mockAuthCode
+}
+
+func newMockOIDCProvider(issuer, clientID, clientSecret string) (*mockOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &mockOIDCProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		kid:          kid,
+		codes:        map[string]*mockAuthCode{},
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *mockOIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if i := strings.Index(path, mockOIDCPath); i >= 0 {
+		path = path[i+len(mockOIDCPath):]
+	}
+	switch {
+	case path == "/.well-known/openid-configuration" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                m.issuer,
+			"authorization_endpoint":                m.issuer + "/authorize",
+			"token_endpoint":                        m.issuer + "/token",
+			"jwks_uri":                              m.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		})
+	case path == "/jwks" && r.Method == http.MethodGet:
+		b64 := base64.RawURLEncoding.EncodeToString
+		pub := m.key.PublicKey
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": m.kid, "use": "sig", "alg": "RS256",
+			"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	case path == "/authorize" && r.Method == http.MethodGet:
+		m.authorize(w, r)
+	case path == "/token" && r.Method == http.MethodPost:
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// client_id 或 redirect_uri 不正确时不能跳转回去, 直接返回错误
+	if q.Get("client_id") != m.clientID || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	back := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" || !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		back(url.Values{"error": {"invalid_request"}, "error_description": {"response_type must be code and scope must include openid"}})
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		back(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with S256 is required"}})
+		return
+	}
+	login := q.Get("login_hint")
+	if login == "" {
+		login = "mockuser"
+	}
+	if login == "deny" {
+		back(url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code, err := randomToken(24)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.mu.Lock()
+	now := time.Now()
+	for k, v := range m.codes {
+		if now.After(v.expiresAt) {
+			delete(m.codes, k)
+		}
+	}
+	m.codes[code] = &mockAuthCode{
+		redirectURI: redirect.String(),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		login:       login,
+		expiresAt:   now.Add(mockOIDCCodeExpire),
+	}
+	m.mu.Unlock()
+	back(url.Values{"code": {code}})
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code, desc string) {
+		writeJSON(w, status, map[string]string{"error": code, "error_description": desc})
+	}
+	if err := r.ParseForm(); err != nil {
+		fail(http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != m.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(m.clientSecret)) != 1 {
+		fail(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// 授权码只能使用一次
+	m.mu.Lock()
+	ac, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || time.Now().After(ac.expiresAt) || ac.redirectURI != r.PostForm.Get("redirect_uri") {
+		fail(http.StatusBadRequest, "invalid_grant", "code is invalid, expired or issued for another redirect_uri")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != ac.challenge {
+		fail(http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &oidcClaims{
+		Nonce:             ac.nonce,
+		Email:             ac.login + "@example.com",
+		EmailVerified:     true,
+		PreferredUsername: ac.login,
+		Name:              ac.login,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   "mock-" + ac.login,
+			Audience:  jwt.ClaimStrings{m.clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	token.Header["kid"] = m.kid
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	accessToken, err := randomToken(24)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// 把请求直接交给 handler 处理, 不建立网络连接
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := &bufferedResponse{header: http.Header{}}
+	t.h.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(w.status) + " " + http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// 缓存 handler 写入的状态码, 响应头和响应体
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponse) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -10,15 +15,45 @@ import (
 
 type Post struct {
 	gorm.Model
-	Title   string
-	Content string
-	UserID  uint
-	User    User
+	Title       string
+	Content     string
+	UserID      uint
+	User        User
+	Tags        []Tag      `gorm:"many2many:post_tags;"`
+	CategoryID  *uint      `gorm:"index"`
+	Status      PostStatus `gorm:"size:16;default:published;index"`
+	PublishAt   *time.Time `gorm:"index"` // 发布时间, scheduled 状态下为计划发布时间
+	Attachments []Attachment
+	// 互动计数, 由 EngagementRepository 同步更新
+	LikeCount      int64
+	BookmarkCount  int64
+	ReactionCounts []ReactionCount `gorm:"polymorphic:Target;polymorphicValue:post"`
+}
+
+type PostStatus string
+
+const (
+	PostDraft     PostStatus = "draft"     // 草稿, 只有作者可见
+	PostScheduled PostStatus = "scheduled" // 到达 publish_at 后自动发布
+	PostPublished PostStatus = "published"
+	PostArchived  PostStatus = "archived" // 归档, 不再公开
+)
+
+func (s PostStatus) Valid() bool {
+	switch s {
+	case PostDraft, PostScheduled, PostPublished, PostArchived:
+		return true
+	}
+	return false
 }
 
 type CreatePostReq struct {
-	Title   string `form:"title" binding:"required,min=1,max=100"`
-	Content string `form:"content" binding:"required,min=1"`
+	Title      string   `form:"title" binding:"required,min=1,max=100"`
+	Content    string   `form:"content" binding:"required,min=1"`
+	Tags       []string `form:"tags"`        // 多个 tags 字段或逗号分隔
+	CategoryID string   `form:"category_id"` // 可选
+	Status     string   `form:"status"`
+	PublishAt  string   `form:"publish_at"`
 }
 
 func getCurrentUserID(c *gin.Context) (uint, bool) {
@@ -35,26 +70,99 @@ func getCurrentUserID(c *gin.Context) (uint, bool) {
 	return uid, true
 }
 
-func getPostAndCheckOwner(c *gin.Context, postID string, userID uint) (*Post, bool) {
-	var post Post
-	if err := db.Where("id = ?", postID).First(&post).Error; err != nil {
+// 检查文章归属, 拥有 anyPerm 权限的角色可以操作任意文章
+func getPostAndCheckOwner(c *gin.Context, postID uint, userID uint, anyPerm Permission) (*Post, bool) {
+	post, err := store.Posts.GetByID(postID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "can't get post"})
 		return nil, false
 	}
-	if post.UserID != userID {
+	if post.UserID != userID && !getCurrentRole(c).Can(anyPerm) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "post is not belongs to the user"})
 		return nil, false
 	}
-	return &post, true
+	return post, true
+}
+
+// 已发布的文章所有人可见, 其他状态只有作者和拥有 post:update_any 权限的角色可见
+func canViewPost(c *gin.Context, post *Post) bool {
+	if post.Status == PostPublished {
+		return true
+	}
+	if uid, ok := c.Get("userID"); ok && uid == post.UserID {
+		return true
+	}
+	return getCurrentRole(c).Can(PermPostUpdateAny)
 }
 
-func validatePostID(c *gin.Context) (string, bool) {
+// 解析 status 和 publish_at, 失败时已写入响应
+// 未指定 status 时, 有未来的 publish_at 则为 scheduled, 否则为 published
+func parsePostStatus(c *gin.Context, status, publishAt string) (PostStatus, *time.Time, bool) {
+	at, err := parseQueryTime(publishAt, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "publish_at must be RFC3339 or 2006-01-02"})
+		return "", nil, false
+	}
+	now := time.Now()
+	s := PostStatus(status)
+	if s == "" {
+		s = PostPublished
+		if at != nil && at.After(now) {
+			s = PostScheduled
+		}
+	}
+	switch {
+	case !s.Valid():
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft, scheduled, published or archived"})
+		return "", nil, false
+	case s == PostScheduled && (at == nil || !at.After(now)):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scheduled post needs a future publish_at"})
+		return "", nil, false
+	case s == PostPublished && at != nil && at.After(now):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "publish_at of published post can't be in the future"})
+		return "", nil, false
+	case s == PostPublished && at == nil:
+		at = &now
+	}
+	return s, at, true
+}
+
+// 未发布时为 null
+func publishAtJSON(post *Post) interface{} {
+	if post.PublishAt == nil {
+		return nil
+	}
+	return post.PublishAt.Format("2006-01-02 15:04:05")
+}
+
+func validatePostID(c *gin.Context) (uint, bool) {
 	postID := c.Param("id")
 	if postID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "post id is null"})
-		return "", false
+		return 0, false
+	}
+	pid, err := strconv.ParseUint(postID, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "post_id format is not correct"})
+		return 0, false
 	}
-	return postID, true
+	return uint(pid), true
+}
+
+// 页码上限, 避免 (page-1)*size 溢出为负的 offset
+const maxPage = 10000
+
+// 解析 page 和 size 参数, 非法时使用默认值, page 超过 maxPage 时按 maxPage 处理
+func parsePage(c *gin.Context, defaultSize, maxSize int) (page, size int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ = strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(defaultSize)))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > maxSize {
+		size = defaultSize
+	}
+	return min(page, maxPage), size
 }
 
 func CreatePostHandler(c *gin.Context) {
@@ -76,31 +184,72 @@ func CreatePostHandler(c *gin.Context) {
 		Content: req.Content,
 		UserID:  uid,
 	}
+	if post.Status, post.PublishAt, ok = parsePostStatus(c, req.Status, req.PublishAt); !ok {
+		return
+	}
+	if req.CategoryID != "" {
+		if post.CategoryID, ok = parseCategoryID(c, req.CategoryID); !ok {
+			return
+		}
+	}
+	names, ok := parseTags(req.Tags)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags must be at most 20 names of at most 32 characters"})
+		return
+	}
+	if len(names) > 0 {
+		tags, err := store.Tags.GetOrCreate(names)
+		if err != nil {
+			zap.L().Error("CreatePost failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "create post failed"})
+			return
+		}
+		post.Tags = tags
+	}
 
-	if err := db.Create(&post).Error; err != nil {
+	if err := store.Posts.Create(&post); err != nil {
 		zap.L().Error("CreatePost failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "create post failed"})
 		return
 	}
 
-	zap.L().Info("CreatePost successfully", zap.Uint("post_id", post.ID), zap.Uint("user_id", uid))
+	searchIndex.IndexPost(&post)
+	fanOutPost(&post)
+
+	zap.L().Info("CreatePost successfully", zap.Uint("post_id", post.ID), zap.Uint("user_id", uid), zap.String("status", string(post.Status)))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"post": gin.H{
-			"id":      post.ID,
-			"title":   post.Title,
-			"content": post.Content,
-			"user_id": post.UserID,
-			"created": post.CreatedAt,
+			"id":           post.ID,
+			"title":        post.Title,
+			"content":      post.Content,
+			"content_html": renderMarkdown(post.Content),
+			"user_id":      post.UserID,
+			"tags":         tagNames(post.Tags),
+			"category_id":  post.CategoryID,
+			"status":       post.Status,
+			"publish_at":   publishAtJSON(&post),
+			"created":      post.CreatedAt,
 		},
 	})
 }
 
+// 文章修改, 由 PostRepository.Edit 在一个事务中完成
+type PostEdit struct {
+	Fields      map[string]interface{}
+	ReplaceTags bool // 为 true 时把标签替换为 Tags(可以为空)
+	Tags        []string
+	EditorID    uint // 不为 0 时以修改后的标题和内容记录一条修订
+}
+
 type UpdatePostReq struct {
 	Title   string `form:"title"`
 	Content string `form:"content"`
 }
 
+// PUT /auth/post/:id 修改文章
+// 提交了 tags 字段(包括空值)时替换全部标签, 提交了 category_id 时修改分类(0 表示清除)
+// 提交了 status 或 publish_at 时修改发布状态
 func UpdatePostHandler(c *gin.Context) {
 	postID, ok := validatePostID(c)
 	if !ok {
@@ -119,32 +268,84 @@ func UpdatePostHandler(c *gin.Context) {
 		zap.L().Error("UpdatePost failed", zap.String("error", "can't get user id"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		return
 	}
-	post, ok := getPostAndCheckOwner(c, postID, uid)
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostUpdateAny)
 	if !ok {
 		return
 	}
 
+	edit := &PostEdit{}
+	// 标题或内容有变化时记录修订
+	if (req.Title != "" && req.Title != post.Title) || (req.Content != "" && req.Content != post.Content) {
+		edit.EditorID = uid
+	}
+
 	updateData := make(map[string]interface{})
 	if req.Title != "" {
 		updateData["Title"] = req.Title
+		post.Title = req.Title
 	}
 	if req.Content != "" {
-		updateData["Content"] = req.Title
+		updateData["Content"] = req.Content
+		post.Content = req.Content
+	}
+	if v, exists := c.GetPostForm("category_id"); exists {
+		if post.CategoryID, ok = parseCategoryID(c, v); !ok {
+			return
+		}
+		updateData["CategoryID"] = post.CategoryID
+	}
+	published := post.Status == PostPublished
+	if c.PostForm("status") != "" || c.PostForm("publish_at") != "" {
+		status, publishAt, ok := parsePostStatus(c, c.PostForm("status"), c.PostForm("publish_at"))
+		if !ok {
+			return
+		}
+		// 重新发布时保留原发布时间
+		if status == PostPublished && published && c.PostForm("publish_at") == "" {
+			publishAt = post.PublishAt
+		}
+		post.Status = status
+		updateData["Status"] = status
+		if publishAt != nil {
+			post.PublishAt = publishAt
+			updateData["PublishAt"] = publishAt
+		}
 	}
-	if err := db.Model(&post).Updates(updateData).Error; err != nil {
+	if values, exists := c.GetPostFormArray("tags"); exists {
+		names, ok := parseTags(values)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tags must be at most 20 names of at most 32 characters"})
+			return
+		}
+		edit.ReplaceTags, edit.Tags = true, names
+	}
+	edit.Fields = updateData
+	if err := store.Posts.Edit(post, edit); err != nil {
 		zap.L().Error("UpdatePost failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if post.Status == PostPublished && !published {
+		searchIndex.IndexPostWithComments(post)
+		fanOutPost(post)
+	} else {
+		searchIndex.IndexPost(post)
+	}
+
 	zap.L().Info("UpdatePost successfully", zap.Uint("post_id", post.ID), zap.Uint("user_id", uid))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"post": gin.H{
-			"id":      post.ID,
-			"title":   post.Title,
-			"content": post.Content,
-			"updated": post.UpdatedAt.Format("2006-01-02 15:04:05"),
+			"id":           post.ID,
+			"title":        post.Title,
+			"content":      post.Content,
+			"content_html": renderMarkdown(post.Content),
+			"tags":         tagNames(post.Tags),
+			"category_id":  post.CategoryID,
+			"status":       post.Status,
+			"publish_at":   publishAtJSON(post),
+			"updated":      post.UpdatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
 }
@@ -156,22 +357,42 @@ func GetPostHandler(c *gin.Context) {
 		return
 	}
 
-	var post Post
-	if err := db.Where("id = ?", postID).First(&post).Error; err != nil {
+	// 对无权查看的文章同样返回 404, 不暴露其存在
+	post, err := store.Posts.GetByID(postID)
+	if err != nil || !canViewPost(c, post) {
 		zap.L().Error("GetPost failed", zap.String("error", "can't get post"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusNotFound, gin.H{"error": "can't get post"})
 		return
 	}
 
+	// 当前用户的点赞和收藏状态
+	var liked, bookmarked bool
+	if uid, ok := c.Get("userID"); ok {
+		if liked, bookmarked, err = store.Engagement.PostState(uid.(uint), post.ID); err != nil {
+			zap.L().Warn("GetPost state failed", zap.Uint("post_id", post.ID), zap.Error(err))
+		}
+	}
+
 	zap.L().Info("GetPost successfully", zap.Uint("post_id", post.ID))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"post": gin.H{
-			"id":      post.ID,
-			"title":   post.Title,
-			"content": post.Content,
-			"created": post.CreatedAt.Format("2006-01-02 15:04:05"),
-			"updated": post.UpdatedAt.Format("2006-01-02 15:04:05"),
+			"id":             post.ID,
+			"title":          post.Title,
+			"content":        post.Content,
+			"content_html":   renderMarkdown(post.Content),
+			"tags":           tagNames(post.Tags),
+			"category_id":    post.CategoryID,
+			"status":         post.Status,
+			"publish_at":     publishAtJSON(post),
+			"like_count":     post.LikeCount,
+			"bookmark_count": post.BookmarkCount,
+			"reactions":      reactionsJSON(post.ReactionCounts),
+			"liked":          liked,
+			"bookmarked":     bookmarked,
+			"attachments":    attachmentsJSON(post.Attachments),
+			"created":        post.CreatedAt.Format("2006-01-02 15:04:05"),
+			"updated":        post.UpdatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
 }
@@ -189,21 +410,255 @@ func DeletePostHandler(c *gin.Context) {
 		return
 	}
 
-	post, ok := getPostAndCheckOwner(c, postID, uid)
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostDeleteAny)
 	if !ok {
 		zap.L().Error("DelPost failed", zap.String("error", "getPostAndCheckOwner failed"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		return
 	}
 
-	if err := db.Delete(&post).Error; err != nil {
+	if err := store.Posts.Delete(post); err != nil {
 		zap.L().Error("DelPost failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	searchIndex.RemovePost(post.ID)
+
 	zap.L().Info("DelPost successfully", zap.Uint("post_id", post.ID))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"post_id": post.ID,
 	})
 }
+
+// 文章列表查询条件
+type PostQuery struct {
+	AuthorID    uint
+	Status      PostStatus
+	TagID       uint
+	CategoryIDs []uint     // 分类及其子分类
+	From        *time.Time // 创建时间范围
+	To          *time.Time
+	SortBy      string // created_at, updated_at, 或 publish_at(订阅源按发布时间排序)
+	Desc        bool
+	Limit       int
+	After       *PostCursor // 上一页最后一条
+}
+
+// 游标, 按 (排序时间, id) 定位, 保证相同时间的文章分页稳定
+type PostCursor struct {
+	SortBy string    `json:"s"`
+	Desc   bool      `json:"d"`
+	Time   time.Time `json:"t"`
+	ID     uint      `json:"i"`
+}
+
+func (p PostCursor) Encode() string {
+	b, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePostCursor(token string) (*PostCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var cur PostCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// 支持 RFC3339 或 2006-01-02 格式, endOfDay 为 true 时日期格式取当天结束(包含当天)
+func parseQueryTime(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+			return nil, err
+		}
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return &t, nil
+}
+
+// 从请求参数解析文章列表查询条件, 失败时已写入响应
+func parsePostQuery(c *gin.Context) (*PostQuery, bool) {
+	q := &PostQuery{SortBy: "created_at", Desc: true, Limit: 20}
+
+	switch c.DefaultQuery("sort", "created") {
+	case "created":
+	case "updated":
+		q.SortBy = "updated_at"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be created or updated"})
+		return nil, false
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		q.Desc = false
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be desc or asc"})
+		return nil, false
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return nil, false
+		}
+		q.Limit = n
+	}
+
+	if v := c.Query("author_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "author_id format is not correct"})
+			return nil, false
+		}
+		q.AuthorID = uint(id)
+	} else if v := c.Query("author"); v != "" {
+		user, err := store.Users.GetByUsername(v)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "can't get author"})
+			return nil, false
+		}
+		q.AuthorID = user.ID
+	}
+
+	// 默认只列出已发布的文章, 其他状态只能查看自己的文章
+	q.Status = PostStatus(c.DefaultQuery("status", string(PostPublished)))
+	if !q.Status.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft, scheduled, published or archived"})
+		return nil, false
+	}
+	if q.Status != PostPublished && !getCurrentRole(c).Can(PermPostUpdateAny) {
+		uid, ok := getCurrentUserID(c)
+		if !ok {
+			return nil, false
+		}
+		if q.AuthorID != 0 && q.AuthorID != uid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "can only list unpublished posts of yourself"})
+			return nil, false
+		}
+		q.AuthorID = uid
+	}
+
+	if v := c.Query("tag"); v != "" {
+		tag, err := store.Tags.GetByName(strings.ToLower(v))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "can't get tag"})
+			return nil, false
+		}
+		q.TagID = tag.ID
+	}
+	if v := c.Query("category_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "category_id format is not correct"})
+			return nil, false
+		}
+		if q.CategoryIDs, err = categoryWithDescendants(uint(id)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+
+	var err error
+	if q.From, err = parseQueryTime(c.Query("from"), false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC3339 or 2006-01-02"})
+		return nil, false
+	}
+	if q.To, err = parseQueryTime(c.Query("to"), true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC3339 or 2006-01-02"})
+		return nil, false
+	}
+
+	if token := c.Query("page_token"); token != "" {
+		cur, err := decodePostCursor(token)
+		if err != nil || cur.SortBy != q.SortBy || cur.Desc != q.Desc {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page_token is invalid"})
+			return nil, false
+		}
+		q.After = cur
+	}
+	return q, true
+}
+
+func postSummaryJSON(post *Post) gin.H {
+	return gin.H{
+		"id":             post.ID,
+		"title":          post.Title,
+		"content":        post.Content,
+		"user_id":        post.UserID,
+		"username":       post.User.Username,
+		"tags":           tagNames(post.Tags),
+		"category_id":    post.CategoryID,
+		"status":         post.Status,
+		"publish_at":     publishAtJSON(post),
+		"like_count":     post.LikeCount,
+		"bookmark_count": post.BookmarkCount,
+		"reactions":      reactionsJSON(post.ReactionCounts),
+		"created":        post.CreatedAt.Format("2006-01-02 15:04:05"),
+		"updated":        post.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// GET /auth/posts 文章列表, 基于游标分页
+func ListPostsHandler(c *gin.Context) {
+	q, ok := parsePostQuery(c)
+	if !ok {
+		return
+	}
+
+	posts, err := store.Posts.List(q)
+	if err != nil {
+		zap.L().Error("ListPosts failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 多查一条判断是否还有下一页
+	nextToken := ""
+	if len(posts) > q.Limit {
+		posts = posts[:q.Limit]
+		last := posts[len(posts)-1]
+		cur := PostCursor{SortBy: q.SortBy, Desc: q.Desc, Time: last.CreatedAt, ID: last.ID}
+		if q.SortBy == "updated_at" {
+			cur.Time = last.UpdatedAt
+		}
+		nextToken = cur.Encode()
+	}
+
+	list := make([]gin.H, 0, len(posts))
+	for i := range posts {
+		list = append(list, postSummaryJSON(&posts[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"posts":           list,
+		"next_page_token": nextToken,
+	})
+}
+
+// 定时发布到期的 scheduled 文章
+func publishScheduledPosts(interval time.Duration) {
+	for range time.Tick(interval) {
+		posts, err := store.Posts.PublishDue(time.Now())
+		if err != nil {
+			zap.L().Error("publish scheduled posts failed", zap.Error(err))
+			continue
+		}
+		for i := range posts {
+			searchIndex.IndexPostWithComments(&posts[i])
+			fanOutPost(&posts[i])
+			zap.L().Info("scheduled post published", zap.Uint("post_id", posts[i].ID))
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxBioLength      = 500
+	minPasswordLength = 8
+)
+
+// 只接受不带显示名的邮箱地址
+func validEmail(v string) bool {
+	addr, err := mail.ParseAddress(v)
+	return err == nil && addr.Address == v
+}
+
+func avatarJSON(user *User) interface{} {
+	if user.Avatar == nil {
+		return nil
+	}
+	return attachmentJSON(user.Avatar)["thumbnail_url"]
+}
+
+// 公开资料, private 为 true 时包含邮箱等只有本人可见的字段
+func profileJSON(user *User, private bool) (gin.H, error) {
+	posts, err := store.Posts.CountPublished(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := store.Follows.CountFollowers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	following, err := store.Follows.CountFollowing(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	h := gin.H{
+		"id":        user.ID,
+		"username":  user.Username,
+		"bio":       user.Bio,
+		"avatar":    avatarJSON(user),
+		"role":      user.Role,
+		"posts":     posts,
+		"followers": followers,
+		"following": following,
+		"created":   user.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if private {
+		h["email"] = user.Email
+		h["email_verified"] = user.EmailVerified
+		h["wallet_address"] = user.WalletAddress
+		h["has_password"] = user.Password != ""
+		h["two_factor_enabled"] = user.TOTPEnabled
+	}
+	return h, nil
+}
+
+func getCurrentUser(c *gin.Context) (*User, bool) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return nil, false
+	}
+	user, err := store.Users.GetByID(uid)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "can't get user"})
+		return nil, false
+	}
+	return user, true
+}
+
+func writeProfile(c *gin.Context, user *User, private bool) {
+	profile, err := profileJSON(user, private)
+	if err != nil {
+		zap.L().Error("Profile failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "user": profile})
+}
+
+// GET /auth/me 当前用户资料
+func GetMeHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	writeProfile(c, user, true)
+}
+
+// GET /users/:id 公开资料
+func PublicProfileHandler(c *gin.Context) {
+	id, ok := validateUserID(c)
+	if !ok {
+		return
+	}
+	user, err := store.Users.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get user"})
+		return
+	}
+	writeProfile(c, user, false)
+}
+
+// PUT /auth/me 修改资料, 可选参数 email, bio, avatar_id(自己上传的图片附件, 0 表示清除)
+func UpdateMeHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	updateData := map[string]interface{}{}
+	if v, exists := c.GetPostForm("email"); exists {
+		if v != "" && !validEmail(v) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email format is not correct"})
+			return
+		}
+		if v != user.Email {
+			updateData["Email"] = v
+			updateData["EmailVerified"] = false
+		}
+	}
+	if v, exists := c.GetPostForm("bio"); exists {
+		if utf8.RuneCountInString(v) > maxBioLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bio must be at most 500 characters"})
+			return
+		}
+		updateData["Bio"] = v
+	}
+	if v, exists := c.GetPostForm("avatar_id"); exists {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "avatar_id format is not correct"})
+			return
+		}
+		if id == 0 {
+			updateData["AvatarID"] = nil
+		} else {
+			att, err := store.Attachments.GetByID(uint(id))
+			if err != nil || att.UserID != user.ID || !isImageType(att.ContentType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be an image uploaded by the user"})
+				return
+			}
+			updateData["AvatarID"] = att.ID
+		}
+	}
+	if len(updateData) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
+	}
+
+	if err := store.Users.Update(user, updateData); err != nil {
+		zap.L().Error("UpdateMe failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user, ok = getCurrentUser(c); !ok {
+		return
+	}
+	if _, changed := updateData["Email"]; changed && user.Email != "" {
+		if err := sendVerificationMail(user); err != nil {
+			zap.L().Error("UpdateMe failed", zap.String("error", "send verification mail: "+err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		}
+	}
+
+	zap.L().Info("UpdateMe successfully", zap.Uint("user_id", user.ID))
+	writeProfile(c, user, true)
+}
+
+// 校验当前密码, 失败时已写入响应; 连续失败计入账号锁定
+// 没有设置密码的用户(钱包或 OIDC 登录)需要重新提交绑定地址的 SIWE 签名, 仅凭 access token 不能操作
+func checkPassword(c *gin.Context, user *User, password string) bool {
+	if checkLoginLocked(c, user.Username) {
+		return false
+	}
+	if user.Password == "" {
+		if user.WalletAddress == nil || c.PostForm("signature") == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account has no password, sign a new SIWE message or set a password through /password/forgot"})
+			return false
+		}
+		addr, err := verifySiwe(c)
+		if err != nil || addr != *user.WalletAddress {
+			recordLoginFailure(c, user.Username, user, LoginBadSignature)
+			c.JSON(http.StatusForbidden, gin.H{"error": "signature is not correct"})
+			return false
+		}
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		recordLoginFailure(c, user.Username, user, LoginBadPassword)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is not correct"})
+		return false
+	}
+	return true
+}
+
+// 吊销当前 access token
+func revokeCurrentToken(c *gin.Context) error {
+	exp := time.Now().Add(cfg.JWT.Expire.Duration)
+	if t, ok := c.Get("tokenExpiresAt"); ok {
+		exp = t.(time.Time)
+	}
+	return store.Tokens.RevokeJTI(c.GetString("tokenID"), exp)
+}
+
+// PUT /auth/me/password 修改密码, 参数 old_password, new_password
+// 成功后吊销所有 refresh token 和当前 access token, 返回新的令牌对
+func ChangePasswordHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	// 第一次设置密码只能走邮件重置流程
+	if user.Password == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account has no password, set one through /password/forgot"})
+		return
+	}
+	newPassword := c.PostForm("new_password")
+	if len(newPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new_password must be at least 8 characters"})
+		return
+	}
+	if !checkPassword(c, user, c.PostForm("old_password")) {
+		zap.L().Warn("ChangePassword failed", zap.Uint("user_id", user.ID), zap.String("error", "Password is not correct"))
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), 10)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password encrypted failed!"})
+		return
+	}
+	err = store.Users.Update(user, map[string]interface{}{"Password": string(hashed)})
+	if err == nil {
+		err = store.Tokens.RevokeUserRefreshTokens(user.ID)
+	}
+	if err == nil {
+		err = revokeCurrentToken(c)
+	}
+	if err != nil {
+		zap.L().Error("ChangePassword failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := issueTokenPair(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generate failed"})
+		return
+	}
+	zap.L().Info("ChangePassword successfully", zap.Uint("user_id", user.ID))
+	tokens["success"] = true
+	c.JSON(http.StatusOK, tokens)
+}
+
+// POST /auth/me/delete 注销账号, 参数 password(没有密码的钱包用户为 message 和 signature), mode
+// mode=anonymize(默认): 保留文章和评论, 作者显示为 deleted-{id}; mode=cascade: 一并删除文章和评论
+func DeleteMeHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	mode := c.DefaultPostForm("mode", "anonymize")
+	if mode != "anonymize" && mode != "cascade" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be anonymize or cascade"})
+		return
+	}
+	if !checkPassword(c, user, c.PostForm("password")) {
+		zap.L().Warn("DeleteMe failed", zap.Uint("user_id", user.ID), zap.String("error", "Password is not correct"))
+		return
+	}
+
+	posts, comments, keys, err := store.Users.DeleteAccount(user, mode == "cascade")
+	if err == ErrLastAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		err = revokeCurrentToken(c)
+	}
+	if err != nil {
+		zap.L().Error("DeleteMe failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, id := range posts {
+		searchIndex.RemovePost(id)
+	}
+	searchIndex.RemoveComments(comments...)
+	deleteBlobs(c.Request.Context(), keys...)
+
+	zap.L().Info("DeleteMe successfully", zap.Uint("user_id", user.ID), zap.String("mode", mode), zap.Int("posts", len(posts)), zap.Int("comments", len(comments)))
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"mode":             mode,
+		"deleted_posts":    len(posts),
+		"deleted_comments": len(comments),
+	})
+}
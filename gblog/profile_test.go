@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeleteAccountCascade(t *testing.T) {
+	r := newTestServer(t, nil)
+	alice := registerTestUser(t, r, "alice")
+	bob := registerTestUser(t, r, "bob")
+
+	id := func(data map[string]interface{}, key string) float64 {
+		return data[key].(map[string]interface{})["id"].(float64)
+	}
+	must := func(method, path, token string, form map[string]string) map[string]interface{} {
+		t.Helper()
+		w, data := doRequest(t, r, method, path, token, form)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		return data
+	}
+
+	alicePost := id(must(http.MethodPost, "/auth/post", alice, map[string]string{"title": "a", "content": "a"}), "post")
+	bobPost := id(must(http.MethodPost, "/auth/post", bob, map[string]string{"title": "b", "content": "b"}), "post")
+	aliceComment := id(must(http.MethodPost, fmt.Sprintf("/auth/post/%.0f/comment", bobPost), alice, map[string]string{"content": "hi"}), "comment")
+	bobReply := id(must(http.MethodPost, fmt.Sprintf("/auth/post/%.0f/comment", bobPost), bob, map[string]string{"content": "re", "parent_id": fmt.Sprintf("%.0f", aliceComment)}), "comment")
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%.0f/like", alicePost), bob, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%.0f/bookmark", alicePost), bob, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%.0f/bookmark", bobPost), alice, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/comment/%.0f/reactions/heart", aliceComment), bob, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%.0f/like", bobPost), alice, nil)
+	must(http.MethodPut, fmt.Sprintf("/auth/post/%.0f/reactions/rocket", bobPost), alice, nil)
+	aliceID := uint(must(http.MethodGet, "/auth/me", alice, nil)["user"].(map[string]interface{})["id"].(float64))
+	att := &Attachment{UserID: aliceID, Key: "alice/avatar.png", ContentType: "image/png"}
+	if err := blobs.Put(context.Background(), att.Key, strings.NewReader("png"), 3, att.ContentType); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Attachments.Create(att); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := store.Users.GetByID(aliceID); err != nil || store.Users.Update(user, map[string]interface{}{"AvatarID": att.ID}) != nil {
+		t.Fatalf("set avatar: %v", err)
+	}
+
+	data := must(http.MethodPost, "/auth/me/delete", alice, map[string]string{"password": "password123", "mode": "cascade"})
+	if data["deleted_posts"] != 1.0 || data["deleted_comments"] != 2.0 {
+		t.Errorf("deleted posts/comments = %v/%v, want 1/2", data["deleted_posts"], data["deleted_comments"])
+	}
+
+	// 其他用户对被删除评论的回复一并删除
+	if _, err := store.Comments.GetByID(uint(bobReply)); err != ErrNotFound {
+		t.Errorf("reply to deleted comment: %v, want ErrNotFound", err)
+	}
+	post, err := store.Posts.GetByID(uint(bobPost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.BookmarkCount != 0 || post.LikeCount != 0 {
+		t.Errorf("bookmark/like count of bob's post = %d/%d, want 0/0", post.BookmarkCount, post.LikeCount)
+	}
+	if counts, _ := store.Engagement.ReactionCounts(TargetPost, uint(bobPost)); len(reactionsJSON(counts)) != 0 {
+		t.Errorf("reactions on bob's post = %v, want none", reactionsJSON(counts))
+	}
+	// 附件记录和文件都已删除
+	if _, err := store.Attachments.GetByKey(att.Key); err != ErrNotFound {
+		t.Errorf("attachment of deleted user: %v, want ErrNotFound", err)
+	}
+	if _, err := blobs.Open(context.Background(), att.Key); err != ErrNotFound {
+		t.Errorf("blob of deleted user: %v, want ErrNotFound", err)
+	}
+	data = must(http.MethodGet, "/auth/bookmarks", bob, nil)
+	if data["total"] != 0.0 {
+		t.Errorf("bob's bookmarks = %v, want 0", data["total"])
+	}
+	if counts, _ := store.Engagement.ReactionCounts(TargetComment, uint(aliceComment)); len(counts) != 0 {
+		t.Errorf("reaction counts of deleted comment = %v, want none", counts)
+	}
+}
+
+func TestPasswordlessAccountNeedsFreshProof(t *testing.T) {
+	r := newOIDCTestServer(t)
+	status, data := browseJSON(t, r, startOIDCLogin(t, r, "alice"))
+	if status != http.StatusOK {
+		t.Fatalf("oidc login: status %d: %v", status, data)
+	}
+	token := data["token"].(string)
+
+	// 只凭 access token 不能设置密码、注销账号或修改两步验证
+	for _, req := range []struct{ path, field string }{
+		{"/auth/me/password", "old_password"},
+		{"/auth/me/delete", "password"},
+		{"/auth/me/2fa/setup", "password"},
+	} {
+		method := http.MethodPost
+		if req.path == "/auth/me/password" {
+			method = http.MethodPut
+		}
+		w, _ := doRequest(t, r, method, req.path, token, map[string]string{req.field: "", "new_password": "password123"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s without a password: status %d, want 403", req.path, w.Code)
+		}
+	}
+	user, err := store.Users.GetByUsername("alice")
+	if err != nil || user.Password != "" || user.TOTPSecret != "" {
+		t.Errorf("passwordless account was modified: %+v, %v", user, err)
+	}
+}
+
+func TestDeleteAccountRevokesOtherSessions(t *testing.T) {
+	r := newTestServer(t, nil)
+	token := registerTestUser(t, r, "alice")
+	_, data := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"})
+	other, _ := data["token"].(string)
+	if other == "" {
+		t.Fatalf("second login: %v", data)
+	}
+
+	if w, data := doRequest(t, r, http.MethodPost, "/auth/me/delete", token, map[string]string{"password": "password123"}); w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %v", w.Code, data)
+	}
+	// 保留内容注销时用户记录仍在, 另一个设备的 token 同样失效
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/me", other, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("other session after delete: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type Role string
+
+const (
+	RoleAdmin     Role = "admin"     // 管理用户, 拥有全部权限
+	RoleModerator Role = "moderator" // 可删除任意文章和评论
+	RoleAuthor    Role = "author"    // 可发布文章, 注册用户默认角色
+	RoleReader    Role = "reader"    // 只能阅读和评论
+)
+
+// 降级或删除最后一个 admin 时返回
+var ErrLastAdmin = errors.New("can't demote or delete the last admin")
+
+type Permission string
+
+const (
+	PermPostCreate       Permission = "post:create"
+	PermPostUpdateAny    Permission = "post:update_any"
+	PermPostDeleteAny    Permission = "post:delete_any"
+	PermCommentCreate    Permission = "comment:create"
+	PermCommentDeleteAny Permission = "comment:delete_any"
+	PermCategoryManage   Permission = "category:manage"
+	PermUserManage       Permission = "user:manage"
+)
+
+// 角色权限表
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermPostCreate, PermPostUpdateAny, PermPostDeleteAny,
+		PermCommentCreate, PermCommentDeleteAny, PermCategoryManage, PermUserManage,
+	},
+	RoleModerator: {PermPostCreate, PermPostDeleteAny, PermCommentCreate, PermCommentDeleteAny, PermCategoryManage},
+	RoleAuthor:    {PermPostCreate, PermCommentCreate},
+	RoleReader:    {PermCommentCreate},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前用户角色, 由 JwtAuthMiddleware 设置
+func getCurrentRole(c *gin.Context) Role {
+	role, _ := c.Get("role")
+	r, _ := role.(Role)
+	return r
+}
+
+// 权限中间件, 需要拥有全部 perms
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := getCurrentRole(c)
+		for _, perm := range perms {
+			if !role.Can(perm) {
+				zap.L().Warn("permission denied", zap.String("role", string(role)), zap.String("permission", string(perm)), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+				c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// 启动时将配置中的用户设为管理员
+func grantAdmins(usernames []string) {
+	for _, name := range usernames {
+		user, err := store.Users.GetByUsername(name)
+		if err != nil {
+			zap.L().Warn("grant admin failed", zap.String("username", name), zap.Error(err))
+			continue
+		}
+		if err := store.Users.SetRole(user.ID, RoleAdmin); err != nil {
+			zap.L().Error("grant admin failed", zap.String("username", name), zap.Error(err))
+		}
+	}
+}
+
+func validateUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user id format is not correct"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// GET /auth/admin/users
+func ListUsersHandler(c *gin.Context) {
+	page, size := parsePage(c, 20, 100)
+
+	users, total, err := store.Users.List((page-1)*size, size)
+	if err != nil {
+		zap.L().Error("ListUsers failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := make([]gin.H, 0, len(users))
+	for _, u := range users {
+		list = append(list, gin.H{
+			"id":       u.ID,
+			"username": u.Username,
+			"email":    u.Email,
+			"role":     u.Role,
+			"created":  u.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"users":   list,
+		"total":   total,
+	})
+}
+
+// PUT /auth/admin/users/:id/role
+func SetUserRoleHandler(c *gin.Context) {
+	id, ok := validateUserID(c)
+	if !ok {
+		return
+	}
+	role := Role(c.PostForm("role"))
+	if !role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be admin, moderator, author or reader"})
+		return
+	}
+	if _, err := store.Users.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get user"})
+		return
+	}
+	if err := store.Users.SetRole(id, role); err != nil {
+		if err == ErrLastAdmin {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		zap.L().Error("SetUserRole failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	uid, _ := getCurrentUserID(c)
+	zap.L().Info("SetUserRole successfully", zap.Uint("user_id", id), zap.String("role", string(role)), zap.Uint("operator", uid))
+	c.JSON(http.StatusOK, gin.H{"success": true, "user_id": id, "role": role})
+}
+
+// DELETE /auth/admin/users/:id?mode=anonymize|cascade 删除用户, mode 含义同 POST /auth/me/delete
+func DeleteUserHandler(c *gin.Context) {
+	id, ok := validateUserID(c)
+	if !ok {
+		return
+	}
+	uid, _ := getCurrentUserID(c)
+	if id == uid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "can't delete yourself"})
+		return
+	}
+	mode := c.DefaultQuery("mode", "anonymize")
+	if mode != "anonymize" && mode != "cascade" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be anonymize or cascade"})
+		return
+	}
+	user, err := store.Users.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get user"})
+		return
+	}
+	// 与用户自己注销相同, 同时清理关注, 令牌等关联数据
+	posts, comments, keys, err := store.Users.DeleteAccount(user, mode == "cascade")
+	if err == ErrLastAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.L().Error("DeleteUser failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, pid := range posts {
+		searchIndex.RemovePost(pid)
+	}
+	searchIndex.RemoveComments(comments...)
+	deleteBlobs(c.Request.Context(), keys...)
+
+	zap.L().Info("DeleteUser successfully", zap.Uint("user_id", id), zap.Uint("operator", uid), zap.String("mode", mode), zap.Int("posts", len(posts)), zap.Int("comments", len(comments)))
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"user_id":          id,
+		"mode":             mode,
+		"deleted_posts":    len(posts),
+		"deleted_comments": len(comments),
+	})
+}
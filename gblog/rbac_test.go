@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRoleChangesTakeEffectImmediately(t *testing.T) {
+	r := newTestServer(t, nil)
+	root := registerTestUser(t, r, "root")
+	grantAdmins([]string{"root"})
+	bob := registerTestUser(t, r, "bob")
+	rootUser, _ := store.Users.GetByUsername("root")
+	bobUser, _ := store.Users.GetByUsername("bob")
+	rolePath := func(id uint) string { return fmt.Sprintf("/auth/admin/users/%d/role", id) }
+
+	// 不能降级唯一的 admin
+	if w, _ := doRequest(t, r, http.MethodPut, rolePath(rootUser.ID), root, map[string]string{"role": "author"}); w.Code != http.StatusConflict {
+		t.Errorf("demote last admin: status %d, want 409", w.Code)
+	}
+	if w, _ := doRequest(t, r, http.MethodPost, "/auth/me/delete", root, map[string]string{"password": "password123"}); w.Code != http.StatusConflict {
+		t.Errorf("delete last admin: status %d, want 409", w.Code)
+	}
+
+	// bob 升为 admin 后, 未重新登录的 token 立即拥有管理权限
+	if w, _ := doRequest(t, r, http.MethodPut, rolePath(bobUser.ID), root, map[string]string{"role": "admin"}); w.Code != http.StatusOK {
+		t.Fatalf("promote bob: status %d", w.Code)
+	}
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/admin/users", bob, nil); w.Code != http.StatusOK {
+		t.Errorf("bob as admin: status %d, want 200", w.Code)
+	}
+
+	// root 被降级后, 旧 token 立即失去管理权限
+	if w, _ := doRequest(t, r, http.MethodPut, rolePath(rootUser.ID), bob, map[string]string{"role": "reader"}); w.Code != http.StatusOK {
+		t.Fatalf("demote root: status %d", w.Code)
+	}
+	if w, _ := doRequest(t, r, http.MethodGet, "/auth/admin/users", root, nil); w.Code != http.StatusForbidden {
+		t.Errorf("demoted root: status %d, want 403", w.Code)
+	}
+}
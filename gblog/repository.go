@@ -0,0 +1,1555 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 记录不存在
+var ErrNotFound = errors.New("record not found")
+
+type UserRepository interface {
+	Create(user *User) error
+	GetByID(id uint) (*User, error)
+	GetByUsername(username string) (*User, error)
+	ListByUsernames(names []string) ([]User, error)
+	ListByEmail(email string) ([]User, error)
+	GetByWallet(address string) (*User, error)
+	SetWallet(userID uint, address string) error
+	// 降级唯一的 admin 时返回 ErrLastAdmin
+	SetRole(userID uint, role Role) error
+	List(offset, limit int) ([]User, int64, error)
+	Update(user *User, fields map[string]interface{}) error
+	// 注销账号: 清除个人信息和关注, 收藏等关系; cascade 为 true 时删除其文章(含文章下所有评论), 评论及评论的回复,
+	// 否则保留内容, 用户名改为 deleted-{id}. 返回被删除的文章和评论 id, 以及需要删除的附件文件 key; 删除唯一的 admin 时返回 ErrLastAdmin
+	DeleteAccount(user *User, cascade bool) (postIDs, commentIDs []uint, blobKeys []string, err error)
+	// 记录使用过的 TOTP 时间步, step 不大于上次使用的时间步时返回 false
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	// 删除用户原有的恢复码并保存新的
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	// 标记恢复码已使用, 不存在或已使用时返回 false
+	UseRecoveryCode(userID uint, hash string) (bool, error)
+	CountRecoveryCodes(userID uint) (int64, error)
+}
+
+type PostRepository interface {
+	// 创建文章并记录第一条修订
+	Create(post *Post) error
+	GetByID(id uint) (*Post, error)
+	Update(post *Post, fields map[string]interface{}) error
+	// 在同一事务中修改字段, 替换标签并记录修订, 见 PostEdit
+	Edit(post *Post, edit *PostEdit) error
+	// 删除文章, 同时删除点赞, 表情回应, 收藏, 通知等关联记录
+	Delete(post *Post) error
+	// 按条件查询, 最多返回 Limit+1 条用于判断是否有下一页
+	List(q *PostQuery) ([]Post, error)
+	ListAll() ([]Post, error)
+	// 将 publish_at 已到的 scheduled 文章改为 published, 返回被发布的文章
+	PublishDue(now time.Time) ([]Post, error)
+	CountPublished(userID uint) (int64, error)
+}
+
+type NotificationRepository interface {
+	Create(list []Notification) error
+	List(userID uint, unreadOnly bool, offset, limit int) ([]Notification, int64, error)
+	CountUnread(userID uint) (int64, error)
+	// 通知不存在或不属于该用户时返回 ErrNotFound
+	MarkRead(userID, id uint) error
+	MarkAllRead(userID uint) error
+}
+
+type CommentRepository interface {
+	Create(comment *Comment) error
+	GetByID(id uint) (*Comment, error)
+	ListByPostID(postID uint) ([]Comment, error)
+	ListAll() ([]Comment, error)
+	Update(comment *Comment, fields map[string]interface{}) error
+	// 删除评论及其所有回复, 以及它们的点赞, 表情回应和通知, 返回被删除的评论 id
+	DeleteTree(comment *Comment) ([]uint, error)
+}
+
+type AttachmentRepository interface {
+	Create(att *Attachment) error
+	GetByID(id uint) (*Attachment, error)
+	// 按文件或缩略图的 key 查询
+	GetByKey(key string) (*Attachment, error)
+	ListByPostID(postID uint) ([]Attachment, error)
+	// 将用户未关联的附件关联到文章, 返回关联的数量
+	AttachToPost(ids []uint, userID, postID uint) (int64, error)
+	Delete(att *Attachment) error
+}
+
+type FollowRepository interface {
+	// 关注和取消关注是幂等的, 返回关系是否发生变化
+	Follow(followerID, followeeID uint) (bool, error)
+	Unfollow(followerID, followeeID uint) (bool, error)
+	CountFollowing(userID uint) (int64, error)
+	CountFollowers(userID uint) (int64, error)
+	ListFollowers(userID uint, offset, limit int) ([]User, int64, error)
+	ListFollowing(userID uint, offset, limit int) ([]User, int64, error)
+}
+
+type TimelineRepository interface {
+	// 实时查询关注的人发布的文章(fan-out-on-read), 最多返回 Limit+1 条
+	FanIn(userID uint, q *FeedQuery) ([]Post, error)
+	// 读取预计算的时间线, 最多返回 Limit+1 条
+	List(userID uint, q *FeedQuery) ([]Post, error)
+	// 将文章写入关注人数不少于 threshold 的关注者的时间线
+	FanOut(post *Post, threshold int) error
+	// 用关注的人最近的 limit 篇文章重建时间线
+	Rebuild(userID uint, limit int) error
+	Clear(userID uint) error
+}
+
+type EngagementRepository interface {
+	// 点赞, 收藏和表情回应都是幂等的, 返回操作后的计数
+	Like(userID uint, kind string, id uint) (int64, error)
+	Unlike(userID uint, kind string, id uint) (int64, error)
+	React(userID uint, kind string, id uint, emoji string) error
+	Unreact(userID uint, kind string, id uint, emoji string) error
+	ReactionCounts(kind string, id uint) ([]ReactionCount, error)
+	Bookmark(userID, postID uint) (int64, error)
+	Unbookmark(userID, postID uint) (int64, error)
+	ListBookmarks(userID uint, offset, limit int) ([]Bookmark, int64, error)
+	// 用户是否点赞, 收藏了文章
+	PostState(userID, postID uint) (liked, bookmarked bool, err error)
+}
+
+type RevisionRepository interface {
+	Get(postID uint, number int) (*PostRevision, error)
+	ListByPostID(postID uint) ([]PostRevision, error)
+}
+
+type TagRepository interface {
+	GetByName(name string) (*Tag, error)
+	// 按名称获取标签, 不存在时创建
+	GetOrCreate(names []string) ([]Tag, error)
+	AttachToPost(post *Post, names []string) ([]Tag, error)
+	DetachFromPost(post *Post, name string) ([]Tag, error)
+	Cloud(limit int) ([]TagCount, error)
+}
+
+type CategoryRepository interface {
+	Create(category *Category) error
+	GetByID(id uint) (*Category, error)
+	List() ([]Category, error)
+	// 各分类直接包含的文章数
+	PostCounts() (map[uint]int64, error)
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(token *RefreshToken) error
+	GetRefreshToken(hash string) (*RefreshToken, error)
+	// 吊销 old 并保存 next, old 已被吊销时返回 ErrTokenReused
+	RotateRefreshToken(old, next *RefreshToken) error
+	RevokeRefreshFamily(familyID string) error
+	RevokeUserRefreshTokens(userID uint) error
+	RevokeJTI(jti string, expiresAt time.Time) error
+	IsJTIRevoked(jti string) (bool, error)
+	// 记录一次性令牌已使用, 返回是否为第一次使用
+	ConsumeJTI(jti string, expiresAt time.Time) (bool, error)
+	PurgeExpired(now time.Time) error
+}
+
+type LoginRepository interface {
+	RecordAttempt(attempt *LoginAttempt) error
+	// 按用户名和 IP 过滤, 为空表示不过滤
+	ListAttempts(username, ip string, offset, limit int) ([]LoginAttempt, int64, error)
+	// 失败次数加一并返回累加后的次数, 上次失败早于 since 时重新计数
+	Fail(subject string, now, since time.Time) (int, error)
+	// 锁定到 until, 已锁定到更晚的时间时不变
+	Lock(subject string, until time.Time) error
+	// subjects 中最晚的解锁时间, 都没有锁定时为零值
+	LockedUntil(subjects []string) (time.Time, error)
+	// subjects 中仍在锁定期的计数, 被拒绝的请求数加一
+	Block(subjects []string, now time.Time) error
+	Reset(subject string) error
+	// 删除 attemptsBefore 之前的审计记录和 idleBefore 之后没有失败的计数
+	Purge(attemptsBefore, idleBefore time.Time) error
+}
+
+type APIKeyRepository interface {
+	Create(key *APIKey) error
+	GetByHash(hash string) (*APIKey, error)
+	ListByUser(userID uint) ([]APIKey, error)
+	// 未吊销且未过期的 key 数量
+	CountActive(userID uint, now time.Time) (int64, error)
+	// 吊销用户自己的 key, 不存在或已吊销时返回 ErrNotFound
+	Revoke(userID, id uint) error
+	// 更新最近使用时间和 IP, 上次使用晚于 before 时不更新
+	Touch(id uint, ip string, now, before time.Time) error
+}
+
+type OIDCRepository interface {
+	CreateState(state *OIDCState) error
+	// 取出并删除未过期的 state, 不存在或已过期时返回 ErrNotFound
+	ConsumeState(state string, now time.Time) (*OIDCState, error)
+	GetIdentity(provider, subject string) (*UserIdentity, error)
+	CreateIdentity(identity *UserIdentity) error
+	// 在同一个事务中创建用户和外部账号
+	CreateUserWithIdentity(user *User, identity *UserIdentity) error
+	ListIdentities(userID uint) ([]UserIdentity, error)
+	DeleteIdentity(userID, id uint) error
+}
+
+type KeyRepository interface {
+	Create(key *SigningKey) error
+	List() ([]SigningKey, error)
+	DeleteExpired(now time.Time) error
+}
+
+type NonceRepository interface {
+	Create(nonce *SiweNonce) error
+	// 删除未过期的 nonce, 不存在或已过期时返回 ErrNotFound
+	Consume(nonce string, now time.Time) error
+}
+
+// 存储层, handler 通过它访问数据
+type Store struct {
+	Users         UserRepository
+	Posts         PostRepository
+	Comments      CommentRepository
+	Revisions     RevisionRepository
+	Attachments   AttachmentRepository
+	Engagement    EngagementRepository
+	Follows       FollowRepository
+	Timelines     TimelineRepository
+	Notifications NotificationRepository
+	Tags          TagRepository
+	Categories    CategoryRepository
+	Tokens        TokenRepository
+	Logins        LoginRepository
+	APIKeys       APIKeyRepository
+	OIDC          OIDCRepository
+	Keys          KeyRepository
+	Nonces        NonceRepository
+}
+
+var store *Store
+
+// 基于 gorm 的存储实现, mysql/postgres/sqlite 共用
+func NewGormStore(gdb *gorm.DB) *Store {
+	return &Store{
+		Users:         &gormUserRepo{db: gdb},
+		Posts:         &gormPostRepo{db: gdb},
+		Comments:      &gormCommentRepo{db: gdb},
+		Revisions:     &gormRevisionRepo{db: gdb},
+		Attachments:   &gormAttachmentRepo{db: gdb},
+		Engagement:    &gormEngagementRepo{db: gdb},
+		Follows:       &gormFollowRepo{db: gdb},
+		Timelines:     &gormTimelineRepo{db: gdb},
+		Notifications: &gormNotificationRepo{db: gdb},
+		Tags:          &gormTagRepo{db: gdb},
+		Categories:    &gormCategoryRepo{db: gdb},
+		Tokens:        &gormTokenRepo{db: gdb},
+		Logins:        &gormLoginRepo{db: gdb},
+		APIKeys:       &gormAPIKeyRepo{db: gdb},
+		OIDC:          &gormOIDCRepo{db: gdb},
+		Keys:          &gormKeyRepo{db: gdb},
+		Nonces:        &gormNonceRepo{db: gdb},
+	}
+}
+
+// 将 gorm 的 ErrRecordNotFound 转换为 ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type gormUserRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepo) Create(user *User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *gormUserRepo) GetByID(id uint) (*User, error) {
+	var user User
+	if err := r.db.Preload("Avatar").First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) GetByUsername(username string) (*User, error) {
+	var user User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) ListByUsernames(names []string) ([]User, error) {
+	var users []User
+	err := r.db.Where("username IN ?", names).Find(&users).Error
+	return users, err
+}
+
+func (r *gormUserRepo) ListByEmail(email string) ([]User, error) {
+	var users []User
+	err := r.db.Where("email = ?", email).Find(&users).Error
+	return users, err
+}
+
+func (r *gormUserRepo) GetByWallet(address string) (*User, error) {
+	var user User
+	if err := r.db.Where("wallet_address = ?", address).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) SetWallet(userID uint, address string) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("wallet_address", address).Error
+}
+
+func (r *gormUserRepo) SetRole(userID uint, role Role) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if role != RoleAdmin {
+			if err := checkLastAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&User{}).Where("id = ?", userID).Update("role", role).Error
+	})
+}
+
+// 用户是唯一的 admin 时返回 ErrLastAdmin
+func checkLastAdmin(tx *gorm.DB, userID uint) error {
+	var admins []uint
+	if err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Pluck("id", &admins).Error; err != nil {
+		return err
+	}
+	if len(admins) == 1 && admins[0] == userID {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func (r *gormUserRepo) List(offset, limit int) ([]User, int64, error) {
+	var users []User
+	var total int64
+	if err := r.db.Model(&User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := r.db.Order("id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *gormUserRepo) Update(user *User, fields map[string]interface{}) error {
+	return r.db.Model(user).Updates(fields).Error
+}
+
+func (r *gormUserRepo) DeleteAccount(user *User, cascade bool) (postIDs, commentIDs []uint, blobKeys []string, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkLastAdmin(tx, user.ID); err != nil {
+			return err
+		}
+
+		// 先减去该用户在其他文章和评论上的点赞, 表情回应计数, 再删除记录
+		for _, kind := range []string{TargetPost, TargetComment} {
+			err := tx.Model(counterModel(kind)).
+				Where("id IN (?) AND like_count > 0", tx.Model(&Like{}).Select("target_id").Where("user_id = ? AND target_type = ?", user.ID, kind)).
+				UpdateColumn("like_count", gorm.Expr("like_count - 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Model(&ReactionCount{}).
+			Where("count > 0 AND EXISTS (?)", tx.Model(&Reaction{}).Select("1").Where("reactions.user_id = ? AND reactions.target_type = reaction_counts.target_type AND reactions.target_id = reaction_counts.target_id AND reactions.emoji = reaction_counts.emoji", user.ID)).
+			UpdateColumn("count", gorm.Expr("count - 1")).Error
+		if err != nil {
+			return err
+		}
+
+		// 头像和未关联文章的附件总是删除; cascade 时文章一并删除, 删除该用户的全部附件
+		attachments := tx.Unscoped().Where("user_id = ?", user.ID)
+		if !cascade {
+			attachments = attachments.Where("post_id IS NULL")
+		}
+		var list []Attachment
+		if err := attachments.Find(&list).Error; err != nil {
+			return err
+		}
+		for _, att := range list {
+			blobKeys = append(blobKeys, att.Key)
+			if att.ThumbKey != "" {
+				blobKeys = append(blobKeys, att.ThumbKey)
+			}
+		}
+
+		cleanups := []*gorm.DB{
+			tx.Where("user_id = ?", user.ID).Delete(&Like{}),
+			tx.Where("user_id = ?", user.ID).Delete(&Reaction{}),
+			tx.Where("follower_id = ? OR followee_id = ?", user.ID, user.ID).Delete(&Follow{}),
+			tx.Where("user_id = ?", user.ID).Delete(&TimelineEntry{}),
+			tx.Where("user_id = ?", user.ID).Delete(&Notification{}),
+			// 先减去收藏计数再删除收藏
+			tx.Model(&Post{}).Where("id IN (?) AND bookmark_count > 0", tx.Model(&Bookmark{}).Select("post_id").Where("user_id = ?", user.ID)).
+				UpdateColumn("bookmark_count", gorm.Expr("bookmark_count - 1")),
+			tx.Where("user_id = ?", user.ID).Delete(&Bookmark{}),
+			tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}),
+			tx.Where("user_id = ?", user.ID).Delete(&UserIdentity{}),
+			tx.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", time.Now()),
+			tx.Model(&APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", time.Now()),
+		}
+		if len(list) > 0 {
+			// 先清除头像引用再删除附件记录
+			cleanups = append(cleanups,
+				tx.Model(user).Omit(clause.Associations).Update("AvatarID", nil),
+				tx.Unscoped().Delete(&list))
+		}
+		for _, res := range cleanups {
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if cascade {
+			if err := tx.Model(&Post{}).Where("user_id = ?", user.ID).Pluck("id", &postIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Comment{}).Where("user_id = ? OR post_id IN ?", user.ID, append(postIDs, 0)).Pluck("id", &commentIDs).Error; err != nil {
+				return err
+			}
+			// 其他用户对被删除评论的回复一并删除, 与删除单条评论时一致
+			for parents := commentIDs; len(parents) > 0; {
+				var replies []uint
+				if err := tx.Model(&Comment{}).Where("parent_id IN ? AND id NOT IN ?", parents, commentIDs).Pluck("id", &replies).Error; err != nil {
+					return err
+				}
+				commentIDs = append(commentIDs, replies...)
+				parents = replies
+			}
+			if err := deleteComments(tx, commentIDs); err != nil {
+				return err
+			}
+			if err := deletePosts(tx, postIDs); err != nil {
+				return err
+			}
+		}
+
+		// 用户名改为 deleted-{id}, 释放原用户名和钱包地址
+		err = tx.Model(user).Omit(clause.Associations).Updates(map[string]interface{}{
+			"Username":      fmt.Sprintf("%s%d", deletedUsernamePrefix, user.ID),
+			"Password":      "",
+			"Email":         "",
+			"Bio":           "",
+			"AvatarID":      nil,
+			"WalletAddress": nil,
+			"Role":          RoleReader,
+			"TOTPSecret":    "",
+			"TOTPEnabled":   false,
+		}).Error
+		if err != nil || !cascade {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	return postIDs, commentIDs, blobKeys, err
+}
+
+// 删除目标的点赞和表情回应, 目标被删除时调用
+func deleteEngagement(tx *gorm.DB, kind string, ids []uint) error {
+	for _, model := range []interface{}{&Like{}, &Reaction{}, &ReactionCount{}} {
+		if err := tx.Where("target_type = ? AND target_id IN ?", kind, ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除评论及其点赞, 表情回应和通知
+func deleteComments(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := deleteEngagement(tx, TargetComment, ids); err != nil {
+		return err
+	}
+	if err := tx.Where("comment_id IN ?", ids).Delete(&Notification{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&Comment{}, ids).Error
+}
+
+// 删除文章及其点赞, 表情回应, 收藏, 时间线, 通知和修订历史
+func deletePosts(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := deleteEngagement(tx, TargetPost, ids); err != nil {
+		return err
+	}
+	related := []interface{}{&Bookmark{}, &TimelineEntry{}, &Notification{}, &PostRevision{}}
+	for _, model := range related {
+		if err := tx.Where("post_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&Post{}, ids).Error
+}
+
+func (r *gormUserRepo) UseTOTPStep(userID uint, step int64) (bool, error) {
+	// 条件更新, 同一个验证码并发提交时只有一个请求能成功
+	res := r.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormUserRepo) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+		codes := make([]RecoveryCode, 0, len(hashes))
+		for _, h := range hashes {
+			codes = append(codes, RecoveryCode{UserID: userID, CodeHash: h})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *gormUserRepo) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	res := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormUserRepo) CountRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+type gormPostRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormPostRepo) Create(post *Post) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+		return createRevision(tx, &PostRevision{PostID: post.ID, Title: post.Title, Content: post.Content, UserID: post.UserID})
+	})
+}
+
+func (r *gormPostRepo) Edit(post *Post, edit *PostEdit) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if edit.EditorID != 0 {
+			// 在修订功能之前创建的文章没有修订记录, 先按修改前的内容补一条
+			var n int64
+			if err := tx.Model(&PostRevision{}).Where("post_id = ?", post.ID).Count(&n).Error; err != nil {
+				return err
+			}
+			if n == 0 {
+				var old Post
+				if err := tx.Select("id", "user_id", "title", "content").First(&old, post.ID).Error; err != nil {
+					return err
+				}
+				if err := createRevision(tx, &PostRevision{PostID: old.ID, Title: old.Title, Content: old.Content, UserID: old.UserID}); err != nil {
+					return err
+				}
+			}
+		}
+		if len(edit.Fields) > 0 {
+			if err := tx.Model(post).Omit(clause.Associations).Updates(edit.Fields).Error; err != nil {
+				return err
+			}
+		}
+		if edit.ReplaceTags {
+			tags, err := getOrCreateTags(tx, edit.Tags)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(post).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+			post.Tags = tags
+		}
+		if edit.EditorID == 0 {
+			return nil
+		}
+		return createRevision(tx, &PostRevision{PostID: post.ID, Title: post.Title, Content: post.Content, UserID: edit.EditorID})
+	})
+}
+
+func (r *gormPostRepo) GetByID(id uint) (*Post, error) {
+	var post Post
+	if err := r.db.Preload("Tags").Preload("Attachments").Preload("ReactionCounts").First(&post, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r *gormPostRepo) Update(post *Post, fields map[string]interface{}) error {
+	return r.db.Model(post).Updates(fields).Error
+}
+
+func (r *gormPostRepo) Delete(post *Post) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return deletePosts(tx, []uint{post.ID})
+	})
+}
+
+func (r *gormPostRepo) List(q *PostQuery) ([]Post, error) {
+	tx := r.db.Preload("User").Preload("Tags").Preload("ReactionCounts")
+	if q.Status != "" {
+		tx = tx.Where("posts.status = ?", q.Status)
+	}
+	if q.AuthorID != 0 {
+		tx = tx.Where("posts.user_id = ?", q.AuthorID)
+	}
+	if q.TagID != 0 {
+		tx = tx.Where("posts.id IN (?)", r.db.Table("post_tags").Select("post_id").Where("tag_id = ?", q.TagID))
+	}
+	if len(q.CategoryIDs) > 0 {
+		tx = tx.Where("posts.category_id IN ?", q.CategoryIDs)
+	}
+	if q.From != nil {
+		tx = tx.Where("posts.created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		tx = tx.Where("posts.created_at < ?", *q.To)
+	}
+
+	// 列名只来自 PostQuery 的固定取值
+	col := "posts." + q.SortBy
+	cmp, order := ">", "ASC"
+	if q.Desc {
+		cmp, order = "<", "DESC"
+	}
+	if q.After != nil {
+		tx = tx.Where(col+" "+cmp+" ? OR ("+col+" = ? AND posts.id "+cmp+" ?)", q.After.Time, q.After.Time, q.After.ID)
+	}
+
+	var posts []Post
+	err := tx.Order(col + " " + order).Order("posts.id " + order).Limit(q.Limit + 1).Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormPostRepo) ListAll() ([]Post, error) {
+	var posts []Post
+	err := r.db.Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormPostRepo) CountPublished(userID uint) (int64, error) {
+	var n int64
+	err := r.db.Model(&Post{}).Where("user_id = ? AND status = ?", userID, PostPublished).Count(&n).Error
+	return n, err
+}
+
+func (r *gormPostRepo) PublishDue(now time.Time) ([]Post, error) {
+	var posts []Post
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("status = ? AND publish_at <= ?", PostScheduled, now).Find(&posts).Error; err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(posts))
+		for i := range posts {
+			ids = append(ids, posts[i].ID)
+			posts[i].Status = PostPublished
+		}
+		return tx.Model(&Post{}).Where("id IN ? AND status = ?", ids, PostScheduled).Update("status", PostPublished).Error
+	})
+	return posts, err
+}
+
+type gormCommentRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormCommentRepo) Create(comment *Comment) error {
+	return r.db.Create(comment).Error
+}
+
+func (r *gormCommentRepo) GetByID(id uint) (*Comment, error) {
+	var comment Comment
+	if err := r.db.Preload("User").Preload("ReactionCounts").First(&comment, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &comment, nil
+}
+
+func (r *gormCommentRepo) ListByPostID(postID uint) ([]Comment, error) {
+	var comments []Comment
+	if err := r.db.Preload("User").Preload("ReactionCounts").Where("post_id = ?", postID).Order("id").Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (r *gormCommentRepo) ListAll() ([]Comment, error) {
+	var comments []Comment
+	err := r.db.Find(&comments).Error
+	return comments, err
+}
+
+func (r *gormCommentRepo) Update(comment *Comment, fields map[string]interface{}) error {
+	return r.db.Model(comment).Updates(fields).Error
+}
+
+func (r *gormCommentRepo) DeleteTree(comment *Comment) ([]uint, error) {
+	var ids []uint
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var all []Comment
+		if err := tx.Select("id", "parent_id").Where("post_id = ?", comment.PostID).Find(&all).Error; err != nil {
+			return err
+		}
+		children := make(map[uint][]uint)
+		for _, c := range all {
+			if c.ParentID != nil {
+				children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+			}
+		}
+		// 广度优先收集所有回复
+		ids = []uint{comment.ID}
+		for i := 0; i < len(ids); i++ {
+			ids = append(ids, children[ids[i]]...)
+		}
+		return deleteComments(tx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+type gormTokenRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormTokenRepo) CreateRefreshToken(token *RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *gormTokenRepo) GetRefreshToken(hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := r.db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r *gormTokenRepo) RotateRefreshToken(old, next *RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// 条件更新, 并发使用同一个令牌时只有一个请求能成功
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTokenReused
+		}
+		return tx.Create(next).Error
+	})
+}
+
+func (r *gormTokenRepo) RevokeRefreshFamily(familyID string) error {
+	return r.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *gormTokenRepo) RevokeUserRefreshTokens(userID uint) error {
+	return r.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *gormTokenRepo) RevokeJTI(jti string, expiresAt time.Time) error {
+	return r.db.Save(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *gormTokenRepo) ConsumeJTI(jti string, expiresAt time.Time) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{JTI: jti, ExpiresAt: expiresAt})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormTokenRepo) IsJTIRevoked(jti string) (bool, error) {
+	var count int64
+	if err := r.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *gormTokenRepo) PurgeExpired(now time.Time) error {
+	if err := r.db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("expires_at < ?", now).Delete(&SiweNonce{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("expires_at < ?", now).Delete(&OIDCState{}).Error; err != nil {
+		return err
+	}
+	return r.db.Unscoped().Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
+
+type gormLoginRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormLoginRepo) RecordAttempt(attempt *LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+func (r *gormLoginRepo) ListAttempts(username, ip string, offset, limit int) ([]LoginAttempt, int64, error) {
+	q := r.db.Model(&LoginAttempt{})
+	if username != "" {
+		q = q.Where("username = ?", username)
+	}
+	if ip != "" {
+		q = q.Where("ip = ?", ip)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []LoginAttempt
+	err := q.Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+func (r *gormLoginRepo) Fail(subject string, now, since time.Time) (int, error) {
+	var throttle LoginThrottle
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&LoginThrottle{Subject: subject, LastFailure: now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&LoginThrottle{}).
+			Where("subject = ? AND last_failure < ?", subject, since).
+			Update("failures", 0).Error; err != nil {
+			return err
+		}
+		// 原子累加, 并发失败不会丢失计数
+		if err := tx.Model(&LoginThrottle{}).Where("subject = ?", subject).
+			Updates(map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_failure": now}).Error; err != nil {
+			return err
+		}
+		return tx.Where("subject = ?", subject).First(&throttle).Error
+	})
+	return throttle.Failures, err
+}
+
+func (r *gormLoginRepo) Lock(subject string, until time.Time) error {
+	return r.db.Model(&LoginThrottle{}).
+		Where("subject = ? AND locked_until < ?", subject, until).
+		Update("locked_until", until).Error
+}
+
+func (r *gormLoginRepo) LockedUntil(subjects []string) (time.Time, error) {
+	var list []LoginThrottle
+	if err := r.db.Where("subject IN ?", subjects).Find(&list).Error; err != nil {
+		return time.Time{}, err
+	}
+	var until time.Time
+	for _, t := range list {
+		if t.LockedUntil.After(until) {
+			until = t.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+func (r *gormLoginRepo) Block(subjects []string, now time.Time) error {
+	return r.db.Model(&LoginThrottle{}).
+		Where("subject IN ? AND locked_until > ?", subjects, now).
+		UpdateColumn("blocked", gorm.Expr("blocked + 1")).Error
+}
+
+func (r *gormLoginRepo) Reset(subject string) error {
+	return r.db.Where("subject = ?", subject).Delete(&LoginThrottle{}).Error
+}
+
+func (r *gormLoginRepo) Purge(attemptsBefore, idleBefore time.Time) error {
+	if err := r.db.Where("created_at < ?", attemptsBefore).Delete(&LoginAttempt{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("last_failure < ? AND locked_until < ?", idleBefore, idleBefore).Delete(&LoginThrottle{}).Error
+}
+
+type gormAPIKeyRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAPIKeyRepo) Create(key *APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *gormAPIKeyRepo) GetByHash(hash string) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &key, nil
+}
+
+func (r *gormAPIKeyRepo) ListByUser(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.db.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormAPIKeyRepo) CountActive(userID uint, now time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *gormAPIKeyRepo) Revoke(userID, id uint) error {
+	res := r.db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormAPIKeyRepo) Touch(id uint, ip string, now, before time.Time) error {
+	return r.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, before).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+}
+
+type gormOIDCRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormOIDCRepo) CreateState(state *OIDCState) error {
+	return r.db.Create(state).Error
+}
+
+func (r *gormOIDCRepo) ConsumeState(state string, now time.Time) (*OIDCState, error) {
+	var s OIDCState
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state = ? AND expires_at > ?", state, now).First(&s).Error; err != nil {
+			return notFound(err)
+		}
+		// 条件删除, 同一个 state 并发回调时只有一个请求能成功
+		res := tx.Where("state = ?", state).Delete(&OIDCState{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *gormOIDCRepo) GetIdentity(provider, subject string) (*UserIdentity, error) {
+	var identity UserIdentity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &identity, nil
+}
+
+func (r *gormOIDCRepo) CreateIdentity(identity *UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *gormOIDCRepo) CreateUserWithIdentity(user *User, identity *UserIdentity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *gormOIDCRepo) ListIdentities(userID uint) ([]UserIdentity, error) {
+	var list []UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *gormOIDCRepo) DeleteIdentity(userID, id uint) error {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&UserIdentity{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type gormKeyRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormKeyRepo) Create(key *SigningKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *gormKeyRepo) List() ([]SigningKey, error) {
+	var list []SigningKey
+	if err := r.db.Order("rotate_at DESC").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *gormKeyRepo) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at < ?", now).Delete(&SigningKey{}).Error
+}
+
+type gormNonceRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormNonceRepo) Create(nonce *SiweNonce) error {
+	return r.db.Create(nonce).Error
+}
+
+func (r *gormNonceRepo) Consume(nonce string, now time.Time) error {
+	result := r.db.Where("nonce = ? AND expires_at > ?", nonce, now).Delete(&SiweNonce{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type gormFollowRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormFollowRepo) Follow(followerID, followeeID uint) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Follow{FollowerID: followerID, FolloweeID: followeeID})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormFollowRepo) Unfollow(followerID, followeeID uint) (bool, error) {
+	res := r.db.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&Follow{})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormFollowRepo) CountFollowers(userID uint) (int64, error) {
+	var n int64
+	err := r.db.Model(&Follow{}).Where("followee_id = ?", userID).Count(&n).Error
+	return n, err
+}
+
+func (r *gormFollowRepo) CountFollowing(userID uint) (int64, error) {
+	var n int64
+	err := r.db.Model(&Follow{}).Where("follower_id = ?", userID).Count(&n).Error
+	return n, err
+}
+
+// 按关注时间倒序列出 userCol 一侧的用户
+func (r *gormFollowRepo) list(userCol, whereCol string, userID uint, offset, limit int) ([]User, int64, error) {
+	var total int64
+	if err := r.db.Model(&Follow{}).Where(whereCol+" = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	err := r.db.Joins("JOIN follows ON follows."+userCol+" = users.id").
+		Where("follows."+whereCol+" = ?", userID).
+		Order("follows.id DESC").Offset(offset).Limit(limit).Find(&users).Error
+	return users, total, err
+}
+
+func (r *gormFollowRepo) ListFollowers(userID uint, offset, limit int) ([]User, int64, error) {
+	return r.list("follower_id", "followee_id", userID, offset, limit)
+}
+
+func (r *gormFollowRepo) ListFollowing(userID uint, offset, limit int) ([]User, int64, error) {
+	return r.list("followee_id", "follower_id", userID, offset, limit)
+}
+
+type gormNotificationRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormNotificationRepo) Create(list []Notification) error {
+	return r.db.Omit("Actor").Create(&list).Error
+}
+
+func (r *gormNotificationRepo) List(userID uint, unreadOnly bool, offset, limit int) ([]Notification, int64, error) {
+	tx := r.db.Model(&Notification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		tx = tx.Where("read_at IS NULL")
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []Notification
+	err := tx.Preload("Actor").Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+func (r *gormNotificationRepo) CountUnread(userID uint) (int64, error) {
+	var n int64
+	err := r.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&n).Error
+	return n, err
+}
+
+func (r *gormNotificationRepo) MarkRead(userID, id uint) error {
+	res := r.db.Model(&Notification{}).Where("id = ? AND user_id = ? AND read_at IS NULL", id, userID).Update("read_at", time.Now())
+	if res.Error != nil || res.RowsAffected == 1 {
+		return res.Error
+	}
+	// 已读的通知重复标记不报错
+	var n int64
+	if err := r.db.Model(&Notification{}).Where("id = ? AND user_id = ?", id, userID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormNotificationRepo) MarkAllRead(userID uint) error {
+	return r.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Update("read_at", time.Now()).Error
+}
+
+type gormTimelineRepo struct {
+	db *gorm.DB
+}
+
+// 按 (col, posts.id) 倒序分页
+func feedPage(tx *gorm.DB, col string, q *FeedQuery) ([]Post, error) {
+	if q.After != nil {
+		tx = tx.Where(col+" < ? OR ("+col+" = ? AND posts.id < ?)", q.After.Time, q.After.Time, q.After.ID)
+	}
+	var posts []Post
+	err := tx.Preload("User").Preload("Tags").Preload("ReactionCounts").
+		Where("posts.status = ?", PostPublished).
+		Order(col + " DESC").Order("posts.id DESC").Limit(q.Limit + 1).Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormTimelineRepo) followees(userID uint) *gorm.DB {
+	return r.db.Model(&Follow{}).Select("followee_id").Where("follower_id = ?", userID)
+}
+
+func (r *gormTimelineRepo) FanIn(userID uint, q *FeedQuery) ([]Post, error) {
+	return feedPage(r.db.Where("posts.user_id IN (?)", r.followees(userID)), "posts.publish_at", q)
+}
+
+func (r *gormTimelineRepo) List(userID uint, q *FeedQuery) ([]Post, error) {
+	tx := r.db.Joins("JOIN timeline_entries ON timeline_entries.post_id = posts.id AND timeline_entries.user_id = ?", userID)
+	return feedPage(tx, "timeline_entries.published_at", q)
+}
+
+func (r *gormTimelineRepo) FanOut(post *Post, threshold int) error {
+	heavy := r.db.Model(&Follow{}).Select("follower_id").Group("follower_id").Having("COUNT(*) >= ?", threshold)
+	var ids []uint
+	if err := r.db.Model(&Follow{}).Where("followee_id = ? AND follower_id IN (?)", post.UserID, heavy).Pluck("follower_id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	entries := make([]TimelineEntry, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, TimelineEntry{UserID: id, PostID: post.ID, AuthorID: post.UserID, PublishedAt: *post.PublishAt})
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(entries, 500).Error
+}
+
+func (r *gormTimelineRepo) Rebuild(userID uint, limit int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&TimelineEntry{}).Error; err != nil {
+			return err
+		}
+		var posts []Post
+		err := tx.Select("id", "user_id", "publish_at").
+			Where("status = ? AND user_id IN (?)", PostPublished, r.followees(userID)).
+			Order("publish_at DESC").Limit(limit).Find(&posts).Error
+		if err != nil || len(posts) == 0 {
+			return err
+		}
+		entries := make([]TimelineEntry, 0, len(posts))
+		for _, p := range posts {
+			entries = append(entries, TimelineEntry{UserID: userID, PostID: p.ID, AuthorID: p.UserID, PublishedAt: *p.PublishAt})
+		}
+		return tx.CreateInBatches(entries, 500).Error
+	})
+}
+
+func (r *gormTimelineRepo) Clear(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&TimelineEntry{}).Error
+}
+
+type gormEngagementRepo struct {
+	db *gorm.DB
+}
+
+// 计数字段所在的表
+func counterModel(kind string) interface{} {
+	if kind == TargetComment {
+		return &Comment{}
+	}
+	return &Post{}
+}
+
+// 插入(add)或删除一条记录, 只有实际发生变化时才更新计数, 保证重复请求和并发下计数一致
+func toggleRow(tx *gorm.DB, add bool, row interface{}) (bool, error) {
+	var res *gorm.DB
+	if add {
+		res = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+	} else {
+		res = tx.Where(row).Delete(row)
+	}
+	return res.RowsAffected == 1, res.Error
+}
+
+// 更新计数字段并返回最新值
+func bumpCounter(tx *gorm.DB, model interface{}, id uint, column string, changed, add bool) (int64, error) {
+	if changed {
+		expr := gorm.Expr(column + " + 1")
+		q := tx.Model(model).Where("id = ?", id)
+		if !add {
+			expr = gorm.Expr(column + " - 1")
+			q = q.Where(column + " > 0")
+		}
+		if err := q.UpdateColumn(column, expr).Error; err != nil {
+			return 0, err
+		}
+	}
+	var count int64
+	err := tx.Model(model).Select(column).Where("id = ?", id).Scan(&count).Error
+	return count, err
+}
+
+func (r *gormEngagementRepo) setLike(add bool, userID uint, kind string, id uint) (int64, error) {
+	var count int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		changed, err := toggleRow(tx, add, &Like{UserID: userID, TargetType: kind, TargetID: id})
+		if err != nil {
+			return err
+		}
+		count, err = bumpCounter(tx, counterModel(kind), id, "like_count", changed, add)
+		return err
+	})
+	return count, err
+}
+
+func (r *gormEngagementRepo) Like(userID uint, kind string, id uint) (int64, error) {
+	return r.setLike(true, userID, kind, id)
+}
+
+func (r *gormEngagementRepo) Unlike(userID uint, kind string, id uint) (int64, error) {
+	return r.setLike(false, userID, kind, id)
+}
+
+func (r *gormEngagementRepo) setReaction(add bool, userID uint, kind string, id uint, emoji string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		changed, err := toggleRow(tx, add, &Reaction{UserID: userID, TargetType: kind, TargetID: id, Emoji: emoji})
+		if err != nil || !changed {
+			return err
+		}
+		if add {
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "target_type"}, {Name: "target_id"}, {Name: "emoji"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("reaction_counts.count + 1")}),
+			}).Create(&ReactionCount{TargetType: kind, TargetID: id, Emoji: emoji, Count: 1}).Error
+		}
+		return tx.Model(&ReactionCount{}).
+			Where("target_type = ? AND target_id = ? AND emoji = ? AND count > 0", kind, id, emoji).
+			UpdateColumn("count", gorm.Expr("count - 1")).Error
+	})
+}
+
+func (r *gormEngagementRepo) React(userID uint, kind string, id uint, emoji string) error {
+	return r.setReaction(true, userID, kind, id, emoji)
+}
+
+func (r *gormEngagementRepo) Unreact(userID uint, kind string, id uint, emoji string) error {
+	return r.setReaction(false, userID, kind, id, emoji)
+}
+
+func (r *gormEngagementRepo) ReactionCounts(kind string, id uint) ([]ReactionCount, error) {
+	var list []ReactionCount
+	err := r.db.Where("target_type = ? AND target_id = ?", kind, id).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *gormEngagementRepo) setBookmark(add bool, userID, postID uint) (int64, error) {
+	var count int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		changed, err := toggleRow(tx, add, &Bookmark{UserID: userID, PostID: postID})
+		if err != nil {
+			return err
+		}
+		count, err = bumpCounter(tx, &Post{}, postID, "bookmark_count", changed, add)
+		return err
+	})
+	return count, err
+}
+
+func (r *gormEngagementRepo) Bookmark(userID, postID uint) (int64, error) {
+	return r.setBookmark(true, userID, postID)
+}
+
+func (r *gormEngagementRepo) Unbookmark(userID, postID uint) (int64, error) {
+	return r.setBookmark(false, userID, postID)
+}
+
+func (r *gormEngagementRepo) ListBookmarks(userID uint, offset, limit int) ([]Bookmark, int64, error) {
+	var list []Bookmark
+	var total int64
+	q := r.db.Model(&Bookmark{}).Where("user_id = ?", userID)
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := q.Preload("Post").Preload("Post.User").Preload("Post.Tags").Preload("Post.ReactionCounts").
+		Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+func (r *gormEngagementRepo) PostState(userID, postID uint) (bool, bool, error) {
+	var likes, bookmarks int64
+	if err := r.db.Model(&Like{}).Where("user_id = ? AND target_type = ? AND target_id = ?", userID, TargetPost, postID).Count(&likes).Error; err != nil {
+		return false, false, err
+	}
+	if err := r.db.Model(&Bookmark{}).Where("user_id = ? AND post_id = ?", userID, postID).Count(&bookmarks).Error; err != nil {
+		return false, false, err
+	}
+	return likes > 0, bookmarks > 0, nil
+}
+
+type gormAttachmentRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAttachmentRepo) Create(att *Attachment) error {
+	return r.db.Create(att).Error
+}
+
+func (r *gormAttachmentRepo) GetByID(id uint) (*Attachment, error) {
+	var att Attachment
+	if err := r.db.First(&att, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &att, nil
+}
+
+func (r *gormAttachmentRepo) GetByKey(key string) (*Attachment, error) {
+	var att Attachment
+	if err := r.db.Where(&Attachment{Key: key}).Or(&Attachment{ThumbKey: key}).First(&att).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &att, nil
+}
+
+func (r *gormAttachmentRepo) ListByPostID(postID uint) ([]Attachment, error) {
+	var list []Attachment
+	err := r.db.Where("post_id = ?", postID).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *gormAttachmentRepo) AttachToPost(ids []uint, userID, postID uint) (int64, error) {
+	tx := r.db.Model(&Attachment{}).
+		Where("id IN ? AND user_id = ? AND post_id IS NULL", ids, userID).
+		Update("post_id", postID)
+	return tx.RowsAffected, tx.Error
+}
+
+// 文件已删除, 记录直接物理删除
+func (r *gormAttachmentRepo) Delete(att *Attachment) error {
+	return r.db.Unscoped().Delete(att).Error
+}
+
+type gormRevisionRepo struct {
+	db *gorm.DB
+}
+
+// 追加修订, 自动分配递增的 Number; 在文章修改的事务中调用
+func createRevision(tx *gorm.DB, rev *PostRevision) error {
+	var last int
+	if err := tx.Model(&PostRevision{}).Where("post_id = ?", rev.PostID).Select("COALESCE(MAX(number), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+	rev.Number = last + 1
+	return tx.Create(rev).Error
+}
+
+func (r *gormRevisionRepo) Get(postID uint, number int) (*PostRevision, error) {
+	var rev PostRevision
+	if err := r.db.Where("post_id = ? AND number = ?", postID, number).First(&rev).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &rev, nil
+}
+
+func (r *gormRevisionRepo) ListByPostID(postID uint) ([]PostRevision, error) {
+	var list []PostRevision
+	err := r.db.Preload("User").Where("post_id = ?", postID).Order("number DESC").Find(&list).Error
+	return list, err
+}
+
+type gormTagRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormTagRepo) GetByName(name string) (*Tag, error) {
+	var tag Tag
+	if err := r.db.Where("name = ?", name).First(&tag).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &tag, nil
+}
+
+func (r *gormTagRepo) GetOrCreate(names []string) ([]Tag, error) {
+	return getOrCreateTags(r.db, names)
+}
+
+func getOrCreateTags(tx *gorm.DB, names []string) ([]Tag, error) {
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tag := Tag{Name: name}
+		if err := tx.Where(Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func postTags(tx *gorm.DB, post *Post) ([]Tag, error) {
+	var tags []Tag
+	err := tx.Model(post).Association("Tags").Find(&tags)
+	return tags, err
+}
+
+func (r *gormTagRepo) AttachToPost(post *Post, names []string) ([]Tag, error) {
+	var tags []Tag
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		current, err := postTags(tx, post)
+		if err != nil {
+			return err
+		}
+		// 先按名称去重检查数量, 超出上限时不创建任何标签
+		seen := make(map[string]bool)
+		for _, t := range current {
+			seen[t.Name] = true
+		}
+		for _, name := range names {
+			seen[name] = true
+		}
+		if len(seen) > maxTagsPerPost {
+			return ErrTooManyTags
+		}
+		added, err := getOrCreateTags(tx, names)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(post).Association("Tags").Append(added); err != nil {
+			return err
+		}
+		tags, err = postTags(tx, post)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (r *gormTagRepo) DetachFromPost(post *Post, name string) ([]Tag, error) {
+	tag, err := r.GetByName(name)
+	if err == nil {
+		if err := r.db.Model(post).Association("Tags").Delete(tag); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return postTags(r.db, post)
+}
+
+func (r *gormTagRepo) Cloud(limit int) ([]TagCount, error) {
+	var counts []TagCount
+	err := r.db.Table("tags").
+		Select("tags.name AS name, COUNT(posts.id) AS count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = ?", PostPublished).
+		Group("tags.id, tags.name").
+		Order("count DESC, tags.name").
+		Limit(limit).
+		Scan(&counts).Error
+	return counts, err
+}
+
+type gormCategoryRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormCategoryRepo) Create(category *Category) error {
+	return r.db.Create(category).Error
+}
+
+func (r *gormCategoryRepo) GetByID(id uint) (*Category, error) {
+	var category Category
+	if err := r.db.First(&category, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &category, nil
+}
+
+func (r *gormCategoryRepo) List() ([]Category, error) {
+	var list []Category
+	err := r.db.Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *gormCategoryRepo) PostCounts() (map[uint]int64, error) {
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	err := r.db.Model(&Post{}).
+		Select("category_id, COUNT(*) AS count").
+		Where("category_id IS NOT NULL AND status = ?", PostPublished).
+		Group("category_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+	return counts, nil
+}
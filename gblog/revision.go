@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 文章修订记录, 每次修改标题或内容追加一条, Number 从 1 开始递增
+type PostRevision struct {
+	ID        uint `gorm:"primarykey"`
+	PostID    uint `gorm:"uniqueIndex:idx_post_revision"`
+	Number    int  `gorm:"uniqueIndex:idx_post_revision"`
+	Title     string
+	Content   string
+	UserID    uint // 修改者
+	User      User
+	CreatedAt time.Time
+}
+
+// 差异行
+type DiffLine struct {
+	Op   string `json:"op"` // "=" 相同, "-" 删除, "+" 新增
+	Text string `json:"text"`
+}
+
+// 超过该行数乘积(去掉相同首尾后)时不做 LCS, 直接视为整体替换; LCS 表最多占用 256KB
+const maxDiffCells = 1 << 16
+
+// 基于最长公共子序列的行级差异
+func diffLines(a, b []string) []DiffLine {
+	// 去掉相同的首尾, 减少 LCS 的计算量
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var out []DiffLine
+	for _, line := range a[:prefix] {
+		out = append(out, DiffLine{"=", line})
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(x)*len(y) > maxDiffCells {
+		for _, line := range x {
+			out = append(out, DiffLine{"-", line})
+		}
+		for _, line := range y {
+			out = append(out, DiffLine{"+", line})
+		}
+	} else {
+		// lcs[i][j] 为 x[i:] 和 y[j:] 的最长公共子序列长度
+		lcs := make([][]int32, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				out = append(out, DiffLine{"=", x[i]})
+				i++
+				j++
+			case j < len(y) && (i == len(x) || lcs[i][j+1] > lcs[i+1][j]):
+				out = append(out, DiffLine{"+", y[j]})
+				j++
+			default:
+				out = append(out, DiffLine{"-", x[i]})
+				i++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		out = append(out, DiffLine{"=", line})
+	}
+	return out
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// 获取文章并检查查看权限, 失败时已写入响应
+func getVisiblePost(c *gin.Context) (*Post, bool) {
+	postID, ok := validatePostID(c)
+	if !ok {
+		return nil, false
+	}
+	post, err := store.Posts.GetByID(postID)
+	if err != nil || !canViewPost(c, post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get post"})
+		return nil, false
+	}
+	return post, true
+}
+
+func parseRevisionNumber(c *gin.Context, value string) (int, bool) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision number format is not correct"})
+		return 0, false
+	}
+	return n, true
+}
+
+// GET /auth/post/:id/revisions 修订列表, 新的在前
+func ListRevisionsHandler(c *gin.Context) {
+	post, ok := getVisiblePost(c)
+	if !ok {
+		return
+	}
+	revisions, err := store.Revisions.ListByPostID(post.ID)
+	if err != nil {
+		zap.L().Error("ListRevisions failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := make([]gin.H, 0, len(revisions))
+	for _, rev := range revisions {
+		list = append(list, gin.H{
+			"number":   rev.Number,
+			"title":    rev.Title,
+			"user_id":  rev.UserID,
+			"username": rev.User.Username,
+			"created":  rev.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"post_id":   post.ID,
+		"revisions": list,
+	})
+}
+
+// GET /auth/post/:id/revisions/diff?from=1&to=2 两个修订之间的行级差异
+func DiffRevisionsHandler(c *gin.Context) {
+	post, ok := getVisiblePost(c)
+	if !ok {
+		return
+	}
+	fromN, ok := parseRevisionNumber(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	toN, ok := parseRevisionNumber(c, c.Query("to"))
+	if !ok {
+		return
+	}
+	from, err := store.Revisions.Get(post.ID, fromN)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get revision"})
+		return
+	}
+	to, err := store.Revisions.Get(post.ID, toN)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get revision"})
+		return
+	}
+
+	lines := diffLines(splitLines(from.Content), splitLines(to.Content))
+	added, removed := 0, 0
+	for _, l := range lines {
+		switch l.Op {
+		case "+":
+			added++
+		case "-":
+			removed++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"post_id": post.ID,
+		"from":    from.Number,
+		"to":      to.Number,
+		"title":   diffLines([]string{from.Title}, []string{to.Title}),
+		"content": lines,
+		"added":   added,
+		"removed": removed,
+	})
+}
+
+// POST /auth/post/:id/revisions/:rev/restore 恢复到旧修订, 恢复结果记为新修订
+func RestoreRevisionHandler(c *gin.Context) {
+	postID, ok := validatePostID(c)
+	if !ok {
+		return
+	}
+	number, ok := parseRevisionNumber(c, c.Param("rev"))
+	if !ok {
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostUpdateAny)
+	if !ok {
+		return
+	}
+	rev, err := store.Revisions.Get(post.ID, number)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get revision"})
+		return
+	}
+
+	post.Title, post.Content = rev.Title, rev.Content
+	edit := &PostEdit{Fields: map[string]interface{}{"Title": rev.Title, "Content": rev.Content}, EditorID: uid}
+	if err := store.Posts.Edit(post, edit); err != nil {
+		zap.L().Error("RestoreRevision failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	searchIndex.IndexPost(post)
+
+	zap.L().Info("RestoreRevision successfully", zap.Uint("post_id", post.ID), zap.Int("revision", number), zap.Uint("user_id", uid))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"post": gin.H{
+			"id":           post.ID,
+			"title":        post.Title,
+			"content":      post.Content,
+			"content_html": renderMarkdown(post.Content),
+			"updated":      post.UpdatedAt.Format("2006-01-02 15:04:05"),
+		},
+		"restored_from": number,
+	})
+}
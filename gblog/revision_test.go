@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateAndRestoreRecordRevisions(t *testing.T) {
+	r := newTestServer(t, nil)
+	token := registerTestUser(t, r, "alice")
+
+	must := func(method, path string, form map[string]string) map[string]interface{} {
+		t.Helper()
+		w, data := doRequest(t, r, method, path, token, form)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		return data
+	}
+	post := must(http.MethodPost, "/auth/post", map[string]string{"title": "v1", "content": "one"})["post"].(map[string]interface{})["id"].(float64)
+	postPath := fmt.Sprintf("/auth/post/%.0f", post)
+
+	data := must(http.MethodPut, postPath, map[string]string{"content": "two", "tags": "go"})
+	if tags := data["post"].(map[string]interface{})["tags"].([]interface{}); len(tags) != 1 || tags[0] != "go" {
+		t.Errorf("tags after update = %v, want [go]", tags)
+	}
+	// 只修改标签不产生修订
+	must(http.MethodPut, postPath, map[string]string{"tags": ""})
+	must(http.MethodPost, postPath+"/revisions/1/restore", nil)
+
+	revisions := must(http.MethodGet, postPath+"/revisions", nil)["revisions"].([]interface{})
+	if len(revisions) != 3 {
+		t.Fatalf("revisions = %v, want 3", revisions)
+	}
+	rev, err := store.Revisions.Get(uint(post), 3)
+	if err != nil || rev.Content != "one" {
+		t.Errorf("restored revision = %+v, %v, want content one", rev, err)
+	}
+	if p, _ := store.Posts.GetByID(uint(post)); p.Content != "one" || len(p.Tags) != 0 {
+		t.Errorf("post after restore = %q %v, want one without tags", p.Content, tagNames(p.Tags))
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "x", "d"})
+	want := []DiffLine{{"=", "a"}, {"-", "b"}, {"=", "c"}, {"+", "x"}, {"=", "d"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diffLines = %v, want %v", got, want)
+	}
+
+	// 改动部分过大时整体替换, 不分配 LCS 表
+	a, b := make([]string, 300), make([]string, 300)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint("a", i), fmt.Sprint("b", i)
+	}
+	got = diffLines(a, b)
+	if len(got) != 600 || got[0].Op != "-" || got[599].Op != "+" {
+		t.Errorf("large diff: %d lines, first %v, last %v", len(got), got[0], got[len(got)-1])
+	}
+}
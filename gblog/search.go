@@ -0,0 +1,420 @@
+package main
+
+import (
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 搜索文档类型
+const (
+	DocPost    = "post"
+	DocComment = "comment"
+)
+
+// BM25 参数
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 2.0 // 标题命中的权重
+)
+
+type docKey struct {
+	Kind string
+	ID   uint
+}
+
+type searchDoc struct {
+	key      docKey
+	postID   uint
+	title    string
+	content  string
+	titleLen int
+	bodyLen  int
+}
+
+type posting struct {
+	titleTF int
+	bodyTF  int
+}
+
+// 内存倒排索引, 启动时从数据库构建, 由文章和评论的增删改同步更新
+type SearchIndex struct {
+	mu       sync.RWMutex
+	docs     map[docKey]*searchDoc
+	postings map[string]map[docKey]*posting
+	titleSum int // 用于计算平均长度
+	bodySum  int
+}
+
+var searchIndex = NewSearchIndex()
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:     make(map[docKey]*searchDoc),
+		postings: make(map[string]map[docKey]*posting),
+	}
+}
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+// 分词: 英文和数字按单词切分并转小写, 中文按单字和相邻双字切分
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var prevHan rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isHan(r):
+			flush()
+			tokens = append(tokens, string(r))
+			if prevHan != 0 {
+				tokens = append(tokens, string([]rune{prevHan, r}))
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prevHan = 0
+	}
+	flush()
+	return tokens
+}
+
+// 查询分词, 中文有双字时只用双字匹配, 提高准确度
+func queryTerms(q string) []string {
+	tokens := tokenize(q)
+	hasBigram := make(map[rune]bool)
+	for _, t := range tokens {
+		if rs := []rune(t); len(rs) == 2 && isHan(rs[0]) {
+			hasBigram[rs[0]], hasBigram[rs[1]] = true, true
+		}
+	}
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range tokens {
+		rs := []rune(t)
+		if len(rs) == 1 && isHan(rs[0]) && hasBigram[rs[0]] {
+			continue
+		}
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func (idx *SearchIndex) removeLocked(key docKey) {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+	for _, t := range tokenize(doc.title + " " + doc.content) {
+		if m := idx.postings[t]; m != nil {
+			delete(m, key)
+			if len(m) == 0 {
+				delete(idx.postings, t)
+			}
+		}
+	}
+	idx.titleSum -= doc.titleLen
+	idx.bodySum -= doc.bodyLen
+	delete(idx.docs, key)
+}
+
+func (idx *SearchIndex) put(doc *searchDoc) {
+	titleTokens, bodyTokens := tokenize(doc.title), tokenize(doc.content)
+	doc.titleLen, doc.bodyLen = len(titleTokens), len(bodyTokens)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(doc.key)
+	idx.docs[doc.key] = doc
+	idx.titleSum += doc.titleLen
+	idx.bodySum += doc.bodyLen
+	get := func(t string) *posting {
+		m := idx.postings[t]
+		if m == nil {
+			m = make(map[docKey]*posting)
+			idx.postings[t] = m
+		}
+		p := m[doc.key]
+		if p == nil {
+			p = &posting{}
+			m[doc.key] = p
+		}
+		return p
+	}
+	for _, t := range titleTokens {
+		get(t).titleTF++
+	}
+	for _, t := range bodyTokens {
+		get(t).bodyTF++
+	}
+}
+
+// 只索引已发布的文章, 其他状态的文章及其评论从索引中移除
+func (idx *SearchIndex) IndexPost(post *Post) {
+	if post.Status != PostPublished {
+		idx.RemovePost(post.ID)
+		return
+	}
+	idx.put(&searchDoc{key: docKey{DocPost, post.ID}, postID: post.ID, title: post.Title, content: post.Content})
+}
+
+// 文章发布时一并索引其评论
+func (idx *SearchIndex) IndexPostWithComments(post *Post) {
+	idx.IndexPost(post)
+	comments, err := store.Comments.ListByPostID(post.ID)
+	if err != nil {
+		zap.L().Error("index comments failed", zap.Uint("post_id", post.ID), zap.Error(err))
+		return
+	}
+	for i := range comments {
+		idx.IndexComment(&comments[i])
+	}
+}
+
+// 文章未被索引(未发布)时忽略
+func (idx *SearchIndex) IndexComment(comment *Comment) {
+	idx.mu.RLock()
+	_, ok := idx.docs[docKey{DocPost, comment.PostID}]
+	idx.mu.RUnlock()
+	if !ok {
+		return
+	}
+	idx.put(&searchDoc{key: docKey{DocComment, comment.ID}, postID: comment.PostID, content: comment.Content})
+}
+
+func (idx *SearchIndex) RemoveComments(ids ...uint) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, id := range ids {
+		idx.removeLocked(docKey{DocComment, id})
+	}
+}
+
+// 删除文章及其评论
+func (idx *SearchIndex) RemovePost(postID uint) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key, doc := range idx.docs {
+		if doc.postID == postID {
+			idx.removeLocked(key)
+		}
+	}
+}
+
+// 从数据库重建索引
+func (idx *SearchIndex) Rebuild() error {
+	posts, err := store.Posts.ListAll()
+	if err != nil {
+		return err
+	}
+	comments, err := store.Comments.ListAll()
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		idx.IndexPost(&posts[i])
+	}
+	for i := range comments {
+		idx.IndexComment(&comments[i])
+	}
+	zap.L().Info("search index built", zap.Int("posts", len(posts)), zap.Int("comments", len(comments)))
+	return nil
+}
+
+type SearchHit struct {
+	Kind    string
+	ID      uint
+	PostID  uint
+	Score   float64
+	Title   string // 高亮后的标题(仅文章)
+	Snippet string // 高亮后的内容片段
+}
+
+// 搜索, kind 为空时同时搜索文章和评论, 返回分页结果和总数
+func (idx *SearchIndex) Search(q, kind string, offset, limit int) ([]SearchHit, int) {
+	terms := queryTerms(q)
+	if len(terms) == 0 {
+		return nil, 0
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	n := float64(len(idx.docs))
+	avgTitle, avgBody := 1.0, 1.0
+	if n > 0 {
+		avgTitle = math.Max(float64(idx.titleSum)/n, 1)
+		avgBody = math.Max(float64(idx.bodySum)/n, 1)
+	}
+	bm25 := func(tf, docLen int, avg float64) float64 {
+		if tf == 0 {
+			return 0
+		}
+		f := float64(tf)
+		return f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(docLen)/avg))
+	}
+
+	scores := make(map[docKey]float64)
+	for _, t := range terms {
+		m := idx.postings[t]
+		if len(m) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(len(m))+0.5)/(float64(len(m))+0.5))
+		for key, p := range m {
+			if kind != "" && key.Kind != kind {
+				continue
+			}
+			doc := idx.docs[key]
+			scores[key] += idf * (titleWeight*bm25(p.titleTF, doc.titleLen, avgTitle) + bm25(p.bodyTF, doc.bodyLen, avgBody))
+		}
+	}
+
+	keys := make([]docKey, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind > keys[j].Kind // 同分时文章在前
+		}
+		return keys[i].ID > keys[j].ID
+	})
+
+	total := len(keys)
+	if offset >= total {
+		return []SearchHit{}, total
+	}
+	keys = keys[offset:min(offset+limit, total)]
+	hits := make([]SearchHit, 0, len(keys))
+	for _, key := range keys {
+		doc := idx.docs[key]
+		hit := SearchHit{Kind: key.Kind, ID: key.ID, PostID: doc.postID, Score: scores[key], Snippet: highlight(doc.content, terms, 80)}
+		if key.Kind == DocPost {
+			hit.Title = highlight(doc.title, terms, 0)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, total
+}
+
+// 截取第一个命中词附近 width 个字符(width 为 0 时不截取), 转义后用 <mark> 标记命中词
+func highlight(text string, terms []string, width int) string {
+	rs := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(rs) {
+		lower = rs
+	}
+	marks := make([]bool, len(rs))
+	first := -1
+	for _, t := range terms {
+		tr := []rune(t)
+		for i := 0; i+len(tr) <= len(lower); i++ {
+			if string(lower[i:i+len(tr)]) != t {
+				continue
+			}
+			// 英文需要整词匹配
+			if !isHan(tr[0]) && ((i > 0 && isWordRune(lower[i-1])) || (i+len(tr) < len(lower) && isWordRune(lower[i+len(tr)]))) {
+				continue
+			}
+			for j := i; j < i+len(tr); j++ {
+				marks[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start, end := 0, len(rs)
+	if width > 0 && len(rs) > width {
+		start = max(first-width/4, 0)
+		end = min(start+width, len(rs))
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	for i := start; i < end; i++ {
+		if marks[i] && (i == start || !marks[i-1]) {
+			b.WriteString("<mark>")
+		}
+		b.WriteString(html.EscapeString(string(rs[i])))
+		if marks[i] && (i == end-1 || !marks[i+1]) {
+			b.WriteString("</mark>")
+		}
+	}
+	if end < len(rs) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return !isHan(r) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// GET /auth/search?q=关键词&type=post|comment&page=1&size=10
+func SearchHandler(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is null"})
+		return
+	}
+	kind := c.Query("type")
+	if kind != "" && kind != DocPost && kind != DocComment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be post or comment"})
+		return
+	}
+	page, size := parsePage(c, 10, 50)
+
+	hits, total := searchIndex.Search(q, kind, (page-1)*size, size)
+	results := make([]gin.H, 0, len(hits))
+	for _, h := range hits {
+		r := gin.H{
+			"type":    h.Kind,
+			"id":      h.ID,
+			"post_id": h.PostID,
+			"score":   math.Round(h.Score*1000) / 1000,
+			"snippet": h.Snippet,
+		}
+		if h.Kind == DocPost {
+			r["title"] = h.Title
+		}
+		results = append(results, r)
+	}
+
+	zap.L().Info("Search successfully", zap.String("q", q), zap.Int("total", total))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"results": results,
+		"total":   total,
+		"page":    page,
+		"size":    size,
+	})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/sha3"
+)
+
+// 登录用的一次性随机数
+type SiweNonce struct {
+	Nonce     string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// EIP-4361 消息
+type SiweMessage struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+const siwePreamble = " wants you to sign in with your Ethereum account:"
+
+// 解析 EIP-4361 消息文本
+func ParseSiweMessage(msg string) (*SiweMessage, error) {
+	lines := strings.Split(msg, "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, errors.New("siwe: missing preamble")
+	}
+	m := &SiweMessage{
+		Domain:  strings.TrimSuffix(lines[0], siwePreamble),
+		Address: lines[1],
+	}
+	if !isHexAddress(m.Address) {
+		return nil, errors.New("siwe: invalid address")
+	}
+
+	// 地址后是空行和可选的 statement, 直到第一个 "URI: " 行
+	i := 2
+	var statement []string
+	for ; i < len(lines) && !strings.HasPrefix(lines[i], "URI: "); i++ {
+		if lines[i] != "" {
+			statement = append(statement, lines[i])
+		}
+	}
+	m.Statement = strings.Join(statement, "\n")
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		if line == "Resources:" {
+			for i++; i < len(lines) && strings.HasPrefix(lines[i], "- "); i++ {
+				m.Resources = append(m.Resources, strings.TrimPrefix(lines[i], "- "))
+			}
+			break
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("siwe: malformed line %q", line)
+		}
+		var err error
+		switch key {
+		case "URI":
+			m.URI = value
+		case "Version":
+			m.Version = value
+		case "Chain ID":
+			m.ChainID, err = strconv.ParseInt(value, 10, 64)
+		case "Nonce":
+			m.Nonce = value
+		case "Issued At":
+			m.IssuedAt, err = time.Parse(time.RFC3339, value)
+		case "Expiration Time":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339, value)
+			m.ExpirationTime = &t
+		case "Not Before":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339, value)
+			m.NotBefore = &t
+		case "Request ID":
+			m.RequestID = value
+		default:
+			return nil, fmt.Errorf("siwe: unknown field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("siwe: invalid %s: %w", key, err)
+		}
+	}
+
+	switch {
+	case m.URI == "":
+		return nil, errors.New("siwe: missing URI")
+	case m.Version != "1":
+		return nil, errors.New("siwe: unsupported version")
+	case m.Nonce == "" || m.IssuedAt.IsZero():
+		return nil, errors.New("siwe: missing nonce or issued at")
+	}
+	return m, nil
+}
+
+// 校验时间窗口
+func (m *SiweMessage) validAt(now time.Time) error {
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return errors.New("siwe: message expired")
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return errors.New("siwe: message not yet valid")
+	}
+	return nil
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func isHexAddress(s string) bool {
+	if len(s) != 42 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+// EIP-55 校验和格式地址
+func checksumAddress(addr []byte) string {
+	lower := hex.EncodeToString(addr)
+	hash := hex.EncodeToString(keccak256([]byte(lower)))
+	out := []byte(lower)
+	for i, ch := range out {
+		if ch >= 'a' && hash[i] >= '8' {
+			out[i] = ch - 32
+		}
+	}
+	return "0x" + string(out)
+}
+
+// 从 personal_sign 签名中恢复签名者地址
+func recoverPersonalSign(msg string, sigHex string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigHex, "0x"))
+	if err != nil || len(sig) != 65 {
+		return "", errors.New("signature must be 65 bytes hex")
+	}
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", errors.New("invalid signature recovery id")
+	}
+
+	hash := keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+	// 转换为 [v, r, s] 紧凑格式
+	compact := make([]byte, 65)
+	compact[0] = 27 + v
+	copy(compact[1:], sig[:64])
+	pub, _, err := ecdsa.RecoverCompact(compact, hash)
+	if err != nil {
+		return "", err
+	}
+	return checksumAddress(keccak256(pub.SerializeUncompressed()[1:])[12:]), nil
+}
+
+// 钱包签名错误, 审计记录中的原因
+const LoginBadSignature = "bad_signature"
+
+// 校验请求中的 SIWE 消息和签名, 返回签名者地址
+func verifySiwe(c *gin.Context) (string, error) {
+	raw, sig := c.PostForm("message"), c.PostForm("signature")
+	if raw == "" || sig == "" {
+		return "", errors.New("message or signature is null")
+	}
+	msg, err := ParseSiweMessage(raw)
+	if err != nil {
+		return "", err
+	}
+	if msg.Domain != cfg.SIWE.Domain {
+		return "", errors.New("siwe: domain mismatch")
+	}
+	if cfg.SIWE.ChainID != 0 && msg.ChainID != cfg.SIWE.ChainID {
+		return "", errors.New("siwe: chain id mismatch")
+	}
+	if err := msg.validAt(time.Now()); err != nil {
+		return "", err
+	}
+
+	addr, err := recoverPersonalSign(raw, sig)
+	if err != nil {
+		return "", err
+	}
+	// 消息中的地址应为 EIP-55 格式, 兼容全小写
+	if addr != msg.Address && strings.ToLower(addr) != msg.Address {
+		return "", errors.New("siwe: signature does not match address")
+	}
+
+	// 签名有效后再消费 nonce, 防止重放
+	if err := store.Nonces.Consume(msg.Nonce, time.Now()); err != nil {
+		return "", errors.New("siwe: nonce is invalid or used")
+	}
+	return addr, nil
+}
+
+// GET /siwe/nonce
+func siweNonceHandler(c *gin.Context) {
+	// EIP-4361 要求 nonce 为字母数字
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "nonce generate failed"})
+		return
+	}
+	nonce := hex.EncodeToString(b)
+	if err := store.Nonces.Create(&SiweNonce{Nonce: nonce, ExpiresAt: time.Now().Add(cfg.SIWE.NonceExpire.Duration)}); err != nil {
+		zap.L().Error("siwe nonce failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "nonce generate failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"nonce":   nonce,
+		"domain":  cfg.SIWE.Domain,
+	})
+}
+
+// POST /siwe/verify 钱包登录, 地址未绑定时自动创建用户
+func siweVerifyHandler(c *gin.Context) {
+	addr, err := verifySiwe(c)
+	if err != nil {
+		zap.L().Error("siwe login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := store.Users.GetByWallet(addr)
+	if errors.Is(err, ErrNotFound) {
+		user = &User{Username: addr, WalletAddress: &addr}
+		err = store.Users.Create(user)
+	}
+	if err != nil {
+		zap.L().Error("siwe login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+
+	zap.L().Info("siwe login successfully", zap.Uint("userID", user.ID), zap.String("address", addr))
+	// 钱包签名只是第一因素, 开启两步验证时同样需要验证码
+	if user.TOTPEnabled {
+		writeTwoFactorChallenge(c, user)
+		return
+	}
+	writeLoginSuccess(c, user)
+}
+
+// POST /auth/siwe/link 为当前用户绑定钱包地址
+func siweLinkHandler(c *gin.Context) {
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	addr, err := verifySiwe(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if owner, err := store.Users.GetByWallet(addr); err == nil && owner.ID != uid {
+		c.JSON(http.StatusConflict, gin.H{"error": "address is linked to another user"})
+		return
+	}
+	if err := store.Users.SetWallet(uid, addr); err != nil {
+		zap.L().Error("siwe link failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "link address failed"})
+		return
+	}
+
+	zap.L().Info("siwe link successfully", zap.Uint("userID", uid), zap.String("address", addr))
+	c.JSON(http.StatusOK, gin.H{"success": true, "address": addr})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// web3.js 文档中 eth.accounts.sign 的示例, 私钥 0x4c0883a6...3f362318
+const (
+	personalSignMessage = "Some data"
+	personalSignAddress = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+	personalSignature   = "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"
+)
+
+func TestRecoverPersonalSign(t *testing.T) {
+	addr, err := recoverPersonalSign(personalSignMessage, personalSignature)
+	if err != nil || addr != personalSignAddress {
+		t.Fatalf("recover: got %s, %v, want %s", addr, err, personalSignAddress)
+	}
+
+	// 部分钱包返回的 v 为 0/1
+	sig, _ := hex.DecodeString(strings.TrimPrefix(personalSignature, "0x"))
+	sig[64] -= 27
+	if addr, err := recoverPersonalSign(personalSignMessage, hex.EncodeToString(sig)); err != nil || addr != personalSignAddress {
+		t.Errorf("recover with v=%d: got %s, %v", sig[64], addr, err)
+	}
+
+	// 消息被修改时恢复出的是其他地址
+	if addr, err := recoverPersonalSign(personalSignMessage+".", personalSignature); err == nil && addr == personalSignAddress {
+		t.Errorf("tampered message recovered the signer address")
+	}
+
+	for _, bad := range []string{"", "0x1234", personalSignature[:len(personalSignature)-2] + "05"} {
+		if _, err := recoverPersonalSign(personalSignMessage, bad); err == nil {
+			t.Errorf("signature %q accepted", bad)
+		}
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	// EIP-55 中的示例地址
+	for _, want := range []string{
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		raw, _ := hex.DecodeString(strings.ToLower(want[2:]))
+		if got := checksumAddress(raw); got != want {
+			t.Errorf("checksum: got %s, want %s", got, want)
+		}
+	}
+}
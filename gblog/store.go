@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 根据驱动名称选择 gorm 方言
+func dialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "mysql":
+		return mysql.Open(dsn), nil
+	case "postgres":
+		return postgres.Open(dsn), nil
+	case "sqlite":
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported db driver %q", driver)
+	}
+}
+
+// 打开数据库并迁移表结构
+func OpenDB(conf DBConfig) (*gorm.DB, error) {
+	d, err := dialector(conf.Driver, conf.DSN)
+	if err != nil {
+		return nil, err
+	}
+	gdb, err := gorm.Open(d, &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("open %s db: %w", conf.Driver, err)
+	}
+	if err := gdb.AutoMigrate(&User{}, &Post{}, &Comment{}, &PostRevision{}, &Attachment{}, &Like{}, &Reaction{}, &ReactionCount{}, &Bookmark{}, &Follow{}, &TimelineEntry{}, &Notification{}, &Tag{}, &Category{}, &RefreshToken{}, &RevokedToken{}, &LoginAttempt{}, &LoginThrottle{}, &RecoveryCode{}, &APIKey{}, &OIDCState{}, &UserIdentity{}, &SigningKey{}, &SiweNonce{}); err != nil {
+		return nil, fmt.Errorf("migrate %s db: %w", conf.Driver, err)
+	}
+	// 发布状态功能之前的文章没有发布时间, 以创建时间补齐, 时间线按发布时间排序
+	if err := gdb.Model(&Post{}).Where("status = ? AND publish_at IS NULL", PostPublished).
+		UpdateColumn("publish_at", gorm.Expr("created_at")).Error; err != nil {
+		return nil, fmt.Errorf("migrate %s db: %w", conf.Driver, err)
+	}
+	return gdb, nil
+}
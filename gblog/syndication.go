@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 订阅源包含的最新文章数
+const syndicationSize = 20
+
+// RSS 2.0
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom 1.0
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// JSON Feed 1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Authors     []jsonFeedName `json:"authors,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedName struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string         `json:"id"`
+	URL           string         `json:"url"`
+	Title         string         `json:"title"`
+	ContentHTML   string         `json:"content_html"`
+	DatePublished string         `json:"date_published"`
+	DateModified  string         `json:"date_modified"`
+	Authors       []jsonFeedName `json:"authors"`
+	Tags          []string       `json:"tags,omitempty"`
+}
+
+func postURL(id uint) string {
+	return strings.TrimRight(cfg.Site.URL, "/") + "/post/" + strconv.FormatUint(uint64(id), 10)
+}
+
+// 订阅源的 ETag, 由文章 id, 修改时间和作者名决定, 不需要渲染内容
+func syndicationETag(format, title string, posts []Post) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", format, title, cfg.Site.URL, cfg.Site.Description)
+	for _, p := range posts {
+		fmt.Fprintf(h, "%d %d %s\n", p.ID, p.UpdatedAt.UnixNano(), p.User.Username)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// 条件请求: If-None-Match 优先, 没有时再比较 If-Modified-Since
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// GET /feeds/:format, /users/:id/feeds/:format 全站和作者的订阅源, format 为 rss, atom 或 json
+func SyndicationHandler(perAuthor bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.Param("format")
+		if format != "rss" && format != "atom" && format != "json" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "format must be rss, atom or json"})
+			return
+		}
+		q := &PostQuery{Status: PostPublished, SortBy: "publish_at", Desc: true, Limit: syndicationSize}
+		title := cfg.Site.Title
+		var author string
+		if perAuthor {
+			id, ok := validateUserID(c)
+			if !ok {
+				return
+			}
+			user, err := store.Users.GetByID(id)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "can't get user"})
+				return
+			}
+			q.AuthorID = user.ID
+			author = user.Username
+			title = cfg.Site.Title + " - " + user.Username
+		}
+
+		posts, err := store.Posts.List(q)
+		if err != nil {
+			zap.L().Error("Syndication failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(posts) > syndicationSize {
+			posts = posts[:syndicationSize]
+		}
+
+		var updated time.Time
+		for _, p := range posts {
+			if p.UpdatedAt.After(updated) {
+				updated = p.UpdatedAt
+			}
+		}
+		etag := syndicationETag(format, title, posts)
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "public, max-age=300")
+		if !updated.IsZero() {
+			c.Header("Last-Modified", updated.UTC().Format(http.TimeFormat))
+		}
+		if notModified(c, etag, updated) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		home := strings.TrimRight(cfg.Site.URL, "/")
+		self := home + c.Request.URL.Path
+		switch format {
+		case "rss":
+			feed := rssFeed{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", Channel: rssChannel{
+				Title:       title,
+				Link:        home,
+				Description: cfg.Site.Description,
+				Self:        atomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+				Items:       make([]rssItem, 0, len(posts)),
+			}}
+			if !updated.IsZero() {
+				feed.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+			}
+			for i := range posts {
+				p := &posts[i]
+				feed.Channel.Items = append(feed.Channel.Items, rssItem{
+					Title:       p.Title,
+					Link:        postURL(p.ID),
+					GUID:        rssGUID{IsPermaLink: true, Value: postURL(p.ID)},
+					PubDate:     p.PublishAt.Format(time.RFC1123Z),
+					Categories:  tagNames(p.Tags),
+					Description: renderMarkdown(p.Content),
+				})
+			}
+			writeXML(c, "application/rss+xml; charset=utf-8", feed)
+		case "atom":
+			if updated.IsZero() {
+				updated = time.Now()
+			}
+			feed := atomFeed{
+				ID:       self,
+				Title:    title,
+				Subtitle: cfg.Site.Description,
+				Updated:  updated.UTC().Format(time.RFC3339),
+				Links: []atomLink{
+					{Href: self, Rel: "self", Type: "application/atom+xml"},
+					{Href: home, Rel: "alternate", Type: "text/html"},
+				},
+				Entries: make([]atomEntry, 0, len(posts)),
+			}
+			for i := range posts {
+				p := &posts[i]
+				categories := make([]atomCategory, 0, len(p.Tags))
+				for _, t := range p.Tags {
+					categories = append(categories, atomCategory{Term: t.Name})
+				}
+				feed.Entries = append(feed.Entries, atomEntry{
+					ID:         postURL(p.ID),
+					Title:      p.Title,
+					Link:       atomLink{Href: postURL(p.ID), Rel: "alternate", Type: "text/html"},
+					Published:  p.PublishAt.UTC().Format(time.RFC3339),
+					Updated:    p.UpdatedAt.UTC().Format(time.RFC3339),
+					Author:     atomPerson{Name: p.User.Username},
+					Categories: categories,
+					Content:    atomContent{Type: "html", Value: renderMarkdown(p.Content)},
+				})
+			}
+			writeXML(c, "application/atom+xml; charset=utf-8", feed)
+		case "json":
+			feed := jsonFeed{
+				Version:     "https://jsonfeed.org/version/1.1",
+				Title:       title,
+				HomePageURL: home,
+				FeedURL:     self,
+				Description: cfg.Site.Description,
+				Items:       make([]jsonFeedItem, 0, len(posts)),
+			}
+			if author != "" {
+				feed.Authors = []jsonFeedName{{Name: author}}
+			}
+			for i := range posts {
+				p := &posts[i]
+				feed.Items = append(feed.Items, jsonFeedItem{
+					ID:            postURL(p.ID),
+					URL:           postURL(p.ID),
+					Title:         p.Title,
+					ContentHTML:   renderMarkdown(p.Content),
+					DatePublished: p.PublishAt.UTC().Format(time.RFC3339),
+					DateModified:  p.UpdatedAt.UTC().Format(time.RFC3339),
+					Authors:       []jsonFeedName{{Name: p.User.Username}},
+					Tags:          tagNames(p.Tags),
+				})
+			}
+			c.Header("Content-Type", "application/feed+json; charset=utf-8")
+			c.JSON(http.StatusOK, feed)
+		}
+	}
+}
+
+func writeXML(c *gin.Context, contentType string, v interface{}) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		zap.L().Error("Syndication failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), out...))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTagsPerPost = 20
+	maxTagLength   = 32
+)
+
+// 追加后文章的标签数超过 maxTagsPerPost
+var ErrTooManyTags = errors.New("too many tags")
+
+type Tag struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:32;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// 标签及其文章数, 用于标签云
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// 分类, 通过 ParentID 组成层级
+type Category struct {
+	gorm.Model
+	Name     string `gorm:"size:64"`
+	ParentID *uint  `gorm:"index"`
+}
+
+// 解析表单中的标签, 支持多个 tags 字段或逗号分隔; 统一转小写并去重
+func parseTags(values []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || seen[name] {
+				continue
+			}
+			if len([]rune(name)) > maxTagLength {
+				return nil, false
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, len(names) <= maxTagsPerPost
+}
+
+func tagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// 解析并校验 category_id, 0 表示不设置分类; 失败时已写入响应
+func parseCategoryID(c *gin.Context, value string) (*uint, bool) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category_id format is not correct"})
+		return nil, false
+	}
+	if id == 0 {
+		return nil, true
+	}
+	if _, err := store.Categories.GetByID(uint(id)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category not exist"})
+		return nil, false
+	}
+	cid := uint(id)
+	return &cid, true
+}
+
+// 分类及其所有子分类的 id
+func categoryWithDescendants(root uint) ([]uint, error) {
+	all, err := store.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[uint][]uint)
+	for _, cat := range all {
+		if cat.ParentID != nil {
+			children[*cat.ParentID] = append(children[*cat.ParentID], cat.ID)
+		}
+	}
+	ids := []uint{root}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids, nil
+}
+
+// POST /auth/post/:id/tags 为文章添加标签
+func AttachTagsHandler(c *gin.Context) {
+	postID, ok := validatePostID(c)
+	if !ok {
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostUpdateAny)
+	if !ok {
+		return
+	}
+
+	names, ok := parseTags(c.PostFormArray("tags"))
+	if !ok || len(names) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags must be 1-20 names of at most 32 characters"})
+		return
+	}
+	tags, err := store.Tags.AttachToPost(post, names)
+	if err == ErrTooManyTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a post can have at most 20 tags"})
+		return
+	}
+	if err != nil {
+		zap.L().Error("AttachTags failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	zap.L().Info("AttachTags successfully", zap.Uint("post_id", post.ID), zap.Strings("tags", names))
+	c.JSON(http.StatusOK, gin.H{"success": true, "post_id": post.ID, "tags": tagNames(tags)})
+}
+
+// DELETE /auth/post/:id/tags/:name 移除文章的标签
+func DetachTagHandler(c *gin.Context) {
+	postID, ok := validatePostID(c)
+	if !ok {
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostUpdateAny)
+	if !ok {
+		return
+	}
+
+	tags, err := store.Tags.DetachFromPost(post, strings.ToLower(c.Param("name")))
+	if err != nil {
+		zap.L().Error("DetachTag failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	zap.L().Info("DetachTag successfully", zap.Uint("post_id", post.ID), zap.String("tag", c.Param("name")))
+	c.JSON(http.StatusOK, gin.H{"success": true, "post_id": post.ID, "tags": tagNames(tags)})
+}
+
+// GET /auth/tags 标签云, 按文章数倒序
+func TagCloudHandler(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	tags, err := store.Tags.Cloud(limit)
+	if err != nil {
+		zap.L().Error("TagCloud failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "tags": tags})
+}
+
+// POST /auth/categories 创建分类, 可选 parent_id
+func CreateCategoryHandler(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" || len([]rune(name)) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-64 characters"})
+		return
+	}
+	category := Category{Name: name}
+	if v := c.PostForm("parent_id"); v != "" {
+		parentID, ok := parseCategoryID(c, v)
+		if !ok {
+			return
+		}
+		category.ParentID = parentID
+	}
+	if err := store.Categories.Create(&category); err != nil {
+		zap.L().Error("CreateCategory failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	zap.L().Info("CreateCategory successfully", zap.Uint("category_id", category.ID), zap.String("name", name))
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"category": gin.H{"id": category.ID, "name": category.Name, "parent_id": category.ParentID},
+	})
+}
+
+// GET /auth/categories 分类树, post_count 包含子分类的文章
+func ListCategoriesHandler(c *gin.Context) {
+	all, err := store.Categories.List()
+	if err != nil {
+		zap.L().Error("ListCategories failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	counts, err := store.Categories.PostCounts()
+	if err != nil {
+		zap.L().Error("ListCategories failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	children := make(map[uint][]Category)
+	var roots []Category
+	for _, cat := range all {
+		if cat.ParentID == nil {
+			roots = append(roots, cat)
+		} else {
+			children[*cat.ParentID] = append(children[*cat.ParentID], cat)
+		}
+	}
+	var build func(cat Category) (gin.H, int64)
+	build = func(cat Category) (gin.H, int64) {
+		total := counts[cat.ID]
+		subs := []gin.H{}
+		for _, child := range children[cat.ID] {
+			node, n := build(child)
+			subs = append(subs, node)
+			total += n
+		}
+		return gin.H{"id": cat.ID, "name": cat.Name, "post_count": total, "children": subs}, total
+	}
+	tree := []gin.H{}
+	for _, cat := range roots {
+		node, _ := build(cat)
+		tree = append(tree, node)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "categories": tree})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttachTagsOverLimitCreatesNothing(t *testing.T) {
+	r := newTestServer(t, nil)
+	token := registerTestUser(t, r, "alice")
+
+	names := make([]string, maxTagsPerPost)
+	for i := range names {
+		names[i] = fmt.Sprintf("tag%d", i)
+	}
+	w, data := doRequest(t, r, http.MethodPost, "/auth/post", token, map[string]string{"title": "a", "content": "a", "tags": strings.Join(names, ",")})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create post: status %d: %v", w.Code, data)
+	}
+	post := data["post"].(map[string]interface{})["id"].(float64)
+
+	w, _ = doRequest(t, r, http.MethodPost, fmt.Sprintf("/auth/post/%.0f/tags", post), token, map[string]string{"tags": "extra"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("attach over limit: status %d, want 400", w.Code)
+	}
+	if _, err := store.Tags.GetByName("extra"); err != ErrNotFound {
+		t.Errorf("rejected tag was created: %v", err)
+	}
+
+	// 已有的标签不重复计数
+	if w, data := doRequest(t, r, http.MethodPost, fmt.Sprintf("/auth/post/%.0f/tags", post), token, map[string]string{"tags": "tag0"}); w.Code != http.StatusOK {
+		t.Errorf("attach existing tag: status %d: %v", w.Code, data)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 刷新令牌, 数据库只保存哈希值
+type RefreshToken struct {
+	gorm.Model
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	FamilyID  string `gorm:"size:64;index"` // 同一次登录轮换出的令牌属于同一个 family
+	UserID    uint   `gorm:"index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// 已吊销的 access token, 过期后即可清理
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// 刷新令牌已被使用过, 可能被盗用
+var ErrTokenReused = errors.New("refresh token reused")
+
+// 生成随机字符串
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 创建刷新令牌, familyID 为空时开启新的 family
+func newRefreshToken(userID uint, familyID string) (string, *RefreshToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(16); err != nil {
+			return "", nil, err
+		}
+	}
+	return raw, &RefreshToken{
+		TokenHash: hashToken(raw),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(cfg.JWT.RefreshExpire.Duration),
+	}, nil
+}
+
+// 签发 access token 和 refresh token
+func issueTokenPair(user *User) (gin.H, error) {
+	token, err := GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	raw, rt, err := newRefreshToken(user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Tokens.CreateRefreshToken(rt); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         token,
+		"refresh_token": raw,
+		"expires_in":    int(cfg.JWT.Expire.Seconds()),
+	}, nil
+}
+
+// 用刷新令牌换取新的令牌对, 旧刷新令牌立即失效
+func refreshTokenHandler(c *gin.Context) {
+	raw := c.PostForm("refresh_token")
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is null"})
+		return
+	}
+
+	old, err := store.Tokens.GetRefreshToken(hashToken(raw))
+	if err != nil || time.Now().After(old.ExpiresAt) {
+		zap.L().Error("refresh token failed", zap.String("error", "refresh token is invalid"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token is invalid"})
+		return
+	}
+	user, err := store.Users.GetByID(old.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token is invalid"})
+		return
+	}
+
+	newRaw, next, err := newRefreshToken(user.ID, old.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generate failed"})
+		return
+	}
+	if err := store.Tokens.RotateRefreshToken(old, next); err != nil {
+		if errors.Is(err, ErrTokenReused) {
+			// 已轮换过的令牌再次出现, 吊销整个 family
+			zap.L().Warn("refresh token reused", zap.Uint("userID", user.ID), zap.String("family", old.FamilyID))
+			store.Tokens.RevokeRefreshFamily(old.FamilyID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token is invalid"})
+			return
+		}
+		zap.L().Error("refresh token failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generate failed"})
+		return
+	}
+
+	token, err := GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generate failed"})
+		return
+	}
+
+	zap.L().Info("refresh token successfully", zap.Uint("userID", user.ID))
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         token,
+		"refresh_token": newRaw,
+		"expires_in":    int(cfg.JWT.Expire.Seconds()),
+	})
+}
+
+// 退出登录: 吊销当前 access token 及传入的 refresh token
+func logoutHandler(c *gin.Context) {
+	jti := c.GetString("tokenID")
+	expiresAt, _ := c.Get("tokenExpiresAt")
+	exp := time.Now().Add(cfg.JWT.Expire.Duration)
+	if t, ok := expiresAt.(time.Time); ok {
+		exp = t
+	}
+	if err := store.Tokens.RevokeJTI(jti, exp); err != nil {
+		zap.L().Error("logout failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "logout failed"})
+		return
+	}
+
+	if raw := c.PostForm("refresh_token"); raw != "" {
+		uid, _ := getCurrentUserID(c)
+		if rt, err := store.Tokens.GetRefreshToken(hashToken(raw)); err == nil && rt.UserID == uid {
+			store.Tokens.RevokeRefreshFamily(rt.FamilyID)
+		}
+	}
+
+	zap.L().Info("logout successfully", zap.String("username", c.GetString("username")))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// 定期清理过期的令牌记录
+func purgeExpiredTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := store.Tokens.PurgeExpired(time.Now()); err != nil {
+			zap.L().Error("purge expired tokens failed", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	r := newTestServer(t, nil)
+	registerTestUser(t, r, "alice")
+
+	login := func() string {
+		t.Helper()
+		w, data := doRequest(t, r, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("login: status %d: %v", w.Code, data)
+		}
+		return data["refresh_token"].(string)
+	}
+	refresh := func(token string) (int, string) {
+		t.Helper()
+		w, data := doRequest(t, r, http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": token})
+		next, _ := data["refresh_token"].(string)
+		return w.Code, next
+	}
+
+	first, other := login(), login()
+	status, second := refresh(first)
+	if status != http.StatusOK || second == "" {
+		t.Fatalf("refresh: status %d", status)
+	}
+
+	// 已轮换的令牌再次使用: 拒绝, 并吊销同一登录下轮换出的所有令牌
+	if status, _ := refresh(first); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: status %d, want 401", status)
+	}
+	if status, _ := refresh(second); status != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked family: status %d, want 401", status)
+	}
+
+	// 其他登录的令牌不受影响
+	if status, _ := refresh(other); status != http.StatusOK {
+		t.Errorf("refresh token of another login: status %d, want 200", status)
+	}
+}
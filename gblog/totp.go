@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RFC 6238 参数, 与常见的验证器应用(Google Authenticator 等)默认值一致
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1 // 允许前后各一个时间步的时钟误差
+
+	recoveryCodeCount = 10
+)
+
+// 两步验证码错误, 审计记录中的原因
+const LoginBadCode = "bad_code"
+
+// 恢复码, 只保存哈希, 每个只能使用一次
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey"`
+	UserID    uint       `gorm:"index"`
+	CodeHash  string     `gorm:"size:64;uniqueIndex"`
+	UsedAt    *time.Time // 使用时间, 为空表示未使用
+	CreatedAt time.Time
+}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 生成 160 位随机密钥, base32 编码
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// RFC 4226 HOTP, 计数器为时间步, 取 digits 位
+func totpCode(key []byte, step int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, v%mod)
+}
+
+// 校验验证码, 返回匹配的时间步
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := b32.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step, totpDigits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 验证器应用扫码使用的 otpauth:// 地址
+func totpURI(secret, account string) string {
+	issuer := cfg.Site.Title
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+// 恢复码格式为 xxxxx-xxxxx, 比较前去掉分隔符并转为小写
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// 生成一组新的恢复码, 返回明文和对应的哈希
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	b := make([]byte, 7)
+	for range recoveryCodeCount {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(b32.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// 校验 TOTP 验证码或恢复码, 成功时标记为已使用
+func verifySecondFactor(user *User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" || user.TOTPSecret == "" {
+		return false, nil
+	}
+	if len(code) == totpDigits && strings.Trim(code, "0123456789") == "" {
+		step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return store.Users.UseTOTPStep(user.ID, step)
+	}
+	if !user.TOTPEnabled {
+		return false, nil
+	}
+	return store.Users.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// 密码正确且开启了两步验证时, 返回 challenge token 代替令牌对
+func writeTwoFactorChallenge(c *gin.Context, user *User) {
+	token, err := newActionToken(user, PurposeTwoFactor, cfg.Login.ChallengeExpire.Duration)
+	if err != nil {
+		zap.L().Error("login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generate failed"})
+		return
+	}
+	zap.L().Info("login challenge issued", zap.Uint("userID", user.ID))
+	c.JSON(http.StatusOK, gin.H{
+		"success":             true,
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(cfg.Login.ChallengeExpire.Seconds()),
+	})
+}
+
+// POST /login/2fa 登录第二步, 参数 challenge_token 和 code(验证码或恢复码)
+func LoginTwoFactorHandler(c *gin.Context) {
+	claims, user, err := parseActionToken(c.PostForm("challenge_token"), PurposeTwoFactor)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if checkLoginLocked(c, user.Username) {
+		return
+	}
+	ok, err := verifySecondFactor(user, c.PostForm("code"))
+	if err != nil {
+		zap.L().Error("login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	if !ok {
+		recordLoginFailure(c, user.Username, user, LoginBadCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "code is not correct"})
+		return
+	}
+	if err := spendActionToken(claims); err != nil {
+		if errors.Is(err, ErrActionToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		zap.L().Error("login failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	resetLoginFailures(user.Username)
+	writeLoginSuccess(c, user)
+}
+
+// 读取当前用户并校验 password 和 code, 失败时已写入响应; 猜测验证码同样计入账号锁定
+func twoFactorUser(c *gin.Context, needCode bool) (*User, bool) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return nil, false
+	}
+	if !checkPassword(c, user, c.PostForm("password")) {
+		return nil, false
+	}
+	if needCode {
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return nil, false
+		}
+		valid, err := verifySecondFactor(user, c.PostForm("code"))
+		if err != nil {
+			zap.L().Error("TwoFactor failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if !valid {
+			recordLoginFailure(c, user.Username, user, LoginBadCode)
+			c.JSON(http.StatusForbidden, gin.H{"error": "code is not correct"})
+			return nil, false
+		}
+	}
+	return user, true
+}
+
+// GET /auth/me/2fa 两步验证状态和剩余恢复码数量
+func TwoFactorStatusHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	left, err := store.Users.CountRecoveryCodes(user.ID)
+	if err != nil {
+		zap.L().Error("TwoFactorStatus failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":             true,
+		"enabled":             user.TOTPEnabled,
+		"recovery_codes_left": left,
+	})
+}
+
+// POST /auth/me/2fa/setup 生成新的密钥, 参数 password; 需要再调用 enable 提交验证码后才生效
+func SetupTwoFactorHandler(c *gin.Context) {
+	user, ok := twoFactorUser(c, false)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err == nil {
+		err = store.Users.Update(user, map[string]interface{}{"TOTPSecret": secret})
+	}
+	if err != nil {
+		zap.L().Error("SetupTwoFactor failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, user.Username),
+	})
+}
+
+// POST /auth/me/2fa/enable 提交验证器中的 code 开启两步验证, 返回恢复码(只显示这一次)
+func EnableTwoFactorHandler(c *gin.Context) {
+	user, ok := getCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "call /auth/me/2fa/setup first"})
+		return
+	}
+	valid, err := verifySecondFactor(user, c.PostForm("code"))
+	if err == nil && !valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "code is not correct"})
+		return
+	}
+	var codes, hashes []string
+	if err == nil {
+		codes, hashes, err = newRecoveryCodes()
+	}
+	if err == nil {
+		err = store.Users.ReplaceRecoveryCodes(user.ID, hashes)
+	}
+	if err == nil {
+		err = store.Users.Update(user, map[string]interface{}{"TOTPEnabled": true})
+	}
+	if err != nil {
+		zap.L().Error("EnableTwoFactor failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("EnableTwoFactor successfully", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true, "recovery_codes": codes})
+}
+
+// POST /auth/me/2fa/disable 关闭两步验证, 参数 password 和 code(验证码或恢复码)
+func DisableTwoFactorHandler(c *gin.Context) {
+	user, ok := twoFactorUser(c, true)
+	if !ok {
+		return
+	}
+	err := store.Users.Update(user, map[string]interface{}{"TOTPSecret": "", "TOTPEnabled": false, "TOTPLastStep": 0})
+	if err == nil {
+		err = store.Users.ReplaceRecoveryCodes(user.ID, nil)
+	}
+	if err != nil {
+		zap.L().Error("DisableTwoFactor failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("DisableTwoFactor successfully", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// POST /auth/me/2fa/recovery-codes 重新生成恢复码, 参数 password 和 code, 之前的恢复码全部失效
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	user, ok := twoFactorUser(c, true)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = store.Users.ReplaceRecoveryCodes(user.ID, hashes)
+	}
+	if err != nil {
+		zap.L().Error("RegenerateRecoveryCodes failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	zap.L().Info("RegenerateRecoveryCodes successfully", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true, "recovery_codes": codes})
+}
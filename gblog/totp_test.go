@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量, 密钥为 ASCII "12345678901234567890"
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range rfc6238Vectors {
+		if got := totpCode(key, v.unix/totpPeriod, 8); got != v.code {
+			t.Errorf("T=%d: got %s, want %s", v.unix, got, v.code)
+		}
+		// 6 位验证码为 8 位结果的后 6 位
+		if got := totpCode(key, v.unix/totpPeriod, totpDigits); got != v.code[2:] {
+			t.Errorf("T=%d: got %s, want %s", v.unix, got, v.code[2:])
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	step, ok := verifyTOTP(secret, "050471", now)
+	if !ok || step != current {
+		t.Fatalf("verify current code: step=%d ok=%v, want step=%d", step, ok, current)
+	}
+	// 允许前后各 totpSkew 个时间步的时钟误差
+	key := []byte("12345678901234567890")
+	for _, d := range []int64{-totpSkew, totpSkew} {
+		if step, ok := verifyTOTP(secret, totpCode(key, current+d, totpDigits), now); !ok || step != current+d {
+			t.Errorf("verify code of step %+d: step=%d ok=%v", d, step, ok)
+		}
+	}
+	for _, d := range []int64{-totpSkew - 1, totpSkew + 1} {
+		if _, ok := verifyTOTP(secret, totpCode(key, current+d, totpDigits), now); ok {
+			t.Errorf("code of step %+d accepted", d)
+		}
+	}
+	for _, code := range []string{"", "14050471", "abcdef"} {
+		if _, ok := verifyTOTP(secret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+// 附件, 上传后可关联到文章
+type Attachment struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	PostID      *uint  `gorm:"index"` // 为空表示尚未关联文章
+	Key         string `gorm:"size:128;uniqueIndex"`
+	ThumbKey    string `gorm:"size:128;index"` // 仅图片有缩略图
+	Filename    string `gorm:"size:255"`
+	ContentType string `gorm:"size:64"`
+	Size        int64
+	Width       int
+	Height      int
+}
+
+// 允许上传的类型及扩展名, 以文件内容判断的类型为准, 不信任客户端声明的类型
+var allowedUploadTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"text/plain":      ".txt",
+}
+
+// 解码图片的最大像素数, 防止解压炸弹
+const maxImagePixels = 50 << 20
+
+// 解析 multipart 表单, maxBody 大于 0 时限制请求体大小
+func parseMultipartForm(c *gin.Context, maxBody int64) error {
+	if maxBody > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+	}
+	// 先调用 ParseMultipartForm 解析, 否则可能无法正确获取字段
+	return c.Request.ParseMultipartForm(32 << 20)
+}
+
+// 根据文件内容判断类型, 不在白名单内时返回空字符串
+func sniffContentType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return ""
+	}
+	if _, ok := allowedUploadTypes[mediaType]; !ok {
+		return ""
+	}
+	return mediaType
+}
+
+func isImageType(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// 生成最长边不超过 size 的缩略图, 原图不大于 size 时返回 nil
+// png/gif 输出 png 以保留透明度, 其他输出 jpeg
+func makeThumbnail(data []byte, contentType string, size int) (thumb []byte, thumbType string, width, height int, err error) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+	width, height = conf.Width, conf.Height
+	if width*height > maxImagePixels {
+		return nil, "", 0, 0, errors.New("image is too large")
+	}
+	if width <= size && height <= size {
+		return nil, "", width, height, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+	tw, th := size, height*size/width
+	if height > width {
+		tw, th = width*size/height, size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(tw, 1), max(th, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/png", "image/gif":
+		thumbType = "image/png"
+		err = png.Encode(&buf, dst)
+	default:
+		thumbType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	}
+	return buf.Bytes(), thumbType, width, height, err
+}
+
+// 存储路径: 年/月/随机串, 随机串不可猜测, 文件可以公开访问
+func newBlobKey(ext string) (string, error) {
+	name, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().Format("2006/01/") + name + ext, nil
+}
+
+func attachmentJSON(a *Attachment) gin.H {
+	h := gin.H{
+		"id":           a.ID,
+		"url":          "/uploads/" + a.Key,
+		"filename":     a.Filename,
+		"content_type": a.ContentType,
+		"size":         a.Size,
+		"post_id":      a.PostID,
+		"created":      a.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if isImageType(a.ContentType) {
+		h["width"], h["height"] = a.Width, a.Height
+		h["thumbnail_url"] = h["url"]
+		if a.ThumbKey != "" {
+			h["thumbnail_url"] = "/uploads/" + a.ThumbKey
+		}
+	}
+	return h
+}
+
+func attachmentsJSON(list []Attachment) []gin.H {
+	out := make([]gin.H, 0, len(list))
+	for i := range list {
+		out = append(out, attachmentJSON(&list[i]))
+	}
+	return out
+}
+
+// POST /auth/upload 上传附件, 字段 file, 可选 post_id 直接关联到自己的文章
+func UploadHandler(c *gin.Context) {
+	maxSize := int64(cfg.Upload.MaxSize) << 20
+	// 请求体额外预留表单字段的空间
+	if err := parseMultipartForm(c, maxSize+1<<20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file must be at most %dMB", cfg.Upload.MaxSize)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parse form failed!"})
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var postID *uint
+	if v := c.PostForm("post_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "post_id format is not correct"})
+			return
+		}
+		post, ok := getPostAndCheckOwner(c, uint(id), uid, PermPostUpdateAny)
+		if !ok {
+			return
+		}
+		postID = &post.ID
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is null"})
+		return
+	}
+	if header.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file must be at most %dMB", cfg.Upload.MaxSize)})
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "can't read file"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil || int64(len(data)) > maxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "can't read file"})
+		return
+	}
+
+	contentType := sniffContentType(data)
+	if contentType == "" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type is not allowed"})
+		return
+	}
+	key, err := newBlobKey(allowedUploadTypes[contentType])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "upload failed"})
+		return
+	}
+	att := &Attachment{
+		UserID:      uid,
+		PostID:      postID,
+		Key:         key,
+		Filename:    path.Base(strings.ReplaceAll(header.Filename, "\\", "/")),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	var thumb []byte
+	var thumbType string
+	if isImageType(contentType) {
+		thumb, thumbType, att.Width, att.Height, err = makeThumbnail(data, contentType, cfg.Upload.ThumbSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image is invalid: " + err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	if err := blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		zap.L().Error("Upload failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "upload failed"})
+		return
+	}
+	if thumb != nil {
+		att.ThumbKey = strings.TrimSuffix(key, path.Ext(key)) + "_thumb" + allowedUploadTypes[thumbType]
+		if err := blobs.Put(ctx, att.ThumbKey, bytes.NewReader(thumb), int64(len(thumb)), thumbType); err != nil {
+			zap.L().Error("Upload failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+			blobs.Delete(ctx, key)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "upload failed"})
+			return
+		}
+	}
+	if err := store.Attachments.Create(att); err != nil {
+		zap.L().Error("Upload failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		blobs.Delete(ctx, key)
+		if att.ThumbKey != "" {
+			blobs.Delete(ctx, att.ThumbKey)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "upload failed"})
+		return
+	}
+
+	zap.L().Info("Upload successfully", zap.Uint("attachment_id", att.ID), zap.Uint("user_id", uid), zap.String("content_type", contentType), zap.Int64("size", att.Size))
+	c.JSON(http.StatusOK, gin.H{"success": true, "attachment": attachmentJSON(att)})
+}
+
+// POST /auth/post/:id/attachments 将自己上传的未关联附件关联到文章, 字段 attachment_ids
+func AttachFilesHandler(c *gin.Context) {
+	postID, ok := validatePostID(c)
+	if !ok {
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	post, ok := getPostAndCheckOwner(c, postID, uid, PermPostUpdateAny)
+	if !ok {
+		return
+	}
+
+	var ids []uint
+	for _, v := range c.PostFormArray("attachment_ids") {
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_ids format is not correct"})
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_ids is null"})
+		return
+	}
+	n, err := store.Attachments.AttachToPost(ids, uid, post.ID)
+	if err != nil {
+		zap.L().Error("AttachFiles failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list, err := store.Attachments.ListByPostID(post.ID)
+	if err != nil {
+		zap.L().Error("AttachFiles failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	zap.L().Info("AttachFiles successfully", zap.Uint("post_id", post.ID), zap.Int64("attached", n))
+	c.JSON(http.StatusOK, gin.H{"success": true, "post_id": post.ID, "attached": n, "attachments": attachmentsJSON(list)})
+}
+
+// DELETE /auth/attachment/:id 上传者或拥有 post:delete_any 权限的角色可以删除
+func DeleteAttachmentHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_id format is not correct"})
+		return
+	}
+	uid, ok := getCurrentUserID(c)
+	if !ok {
+		return
+	}
+	att, err := store.Attachments.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "can't get attachment"})
+		return
+	}
+	if att.UserID != uid && !getCurrentRole(c).Can(PermPostDeleteAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "attachment is not belongs to the user"})
+		return
+	}
+
+	if err := store.Attachments.Delete(att); err != nil {
+		zap.L().Error("DeleteAttachment failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// 记录已删除, 文件删除失败只记录日志
+	deleteBlobs(c.Request.Context(), att.Key, att.ThumbKey)
+
+	zap.L().Info("DeleteAttachment successfully", zap.Uint("attachment_id", att.ID), zap.Uint("user_id", uid))
+	c.JSON(http.StatusOK, gin.H{"success": true, "attachment_id": att.ID})
+}
+
+// 删除附件文件, 失败只记录日志; 空 key 忽略
+func deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := blobs.Delete(ctx, key); err != nil {
+			zap.L().Warn("delete blob failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// GET /uploads/*key 读取附件或缩略图
+func ServeUploadHandler(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	att, err := store.Attachments.GetByKey(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	r, err := blobs.Open(c.Request.Context(), key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			zap.L().Error("ServeUpload failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	defer r.Close()
+
+	contentType := att.ContentType
+	if key == att.ThumbKey {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	// 非图片一律作为下载处理
+	if !isImageType(contentType) {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+	}
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		zap.L().Warn("ServeUpload interrupted", zap.String("key", key), zap.Error(err))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -11,9 +12,18 @@ import (
 
 type User struct {
 	gorm.Model
-	Username string `gorm:"unique" form:"username" binding:"required"`
-	Password string `form:"password" binding:"required"`
-	Email    string `form:"email"`
+	Username      string      `gorm:"unique" form:"username" binding:"required"`
+	Password      string      `form:"password" binding:"required"`
+	Email         string      `gorm:"index" form:"email"`
+	EmailVerified bool        `form:"-"`
+	WalletAddress *string     `gorm:"uniqueIndex;size:42" form:"-"` // 以太坊钱包地址(EIP-55)
+	Role          Role        `gorm:"size:16;default:author" form:"-"`
+	Bio           string      `gorm:"size:500" form:"-"`
+	AvatarID      *uint       `form:"-"` // 头像, 自己上传的图片附件
+	Avatar        *Attachment `gorm:"foreignKey:AvatarID" form:"-"`
+	TOTPSecret    string      `gorm:"size:64" form:"-"` // base32, 未启用时为待确认的密钥
+	TOTPEnabled   bool        `form:"-"`
+	TOTPLastStep  int64       `form:"-"` // 最近一次使用的时间步, 同一个验证码不能重复使用
 }
 
 type LoginUser struct {
@@ -25,8 +35,7 @@ type LoginUser struct {
 // 密码加密中间件
 func PasswordEncrypt() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 先调用 ParseMultipartForm 解析, 否则可能无法正确获取字段
-		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		if err := parseMultipartForm(c, 0); err != nil {
 			c.JSON(500, gin.H{
 				"error": "Parse form failed!",
 			})
@@ -58,6 +67,20 @@ func PasswordEncrypt() gin.HandlerFunc {
 	}
 }
 
+// 注销(anonymize)的用户改名为 deleted-{id}
+const deletedUsernamePrefix = "deleted-"
+
+// 钱包登录自动创建的用户以地址为用户名, 注销的用户改名为 deleted-{id}, 这两种用户名不能注册
+func reservedUsername(name string) bool {
+	name = strings.ToLower(name)
+	return isHexAddress(name) || strings.HasPrefix(name, deletedUsernamePrefix)
+}
+
+// 账号是否已注销; 保留内容注销时用户记录仍然存在
+func accountDeleted(user *User) bool {
+	return strings.HasPrefix(user.Username, deletedUsernamePrefix)
+}
+
 // 用户注册
 func registerHandler(c *gin.Context) {
 	var user User
@@ -69,6 +92,14 @@ func registerHandler(c *gin.Context) {
 		c.Abort()
 		return
 	}
+	if reservedUsername(user.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is reserved"})
+		return
+	}
+	if user.Email != "" && !validEmail(user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email format is not correct"})
+		return
+	}
 	// 从上下文获取加密后的密码
 	hashedPassword, exists := c.Get("hashedPassword")
 	if !exists {
@@ -78,7 +109,7 @@ func registerHandler(c *gin.Context) {
 	}
 	user.Password = hashedPassword.(string)
 	// 创建
-	if err := db.Create(&user).Error; err != nil {
+	if err := store.Users.Create(&user); err != nil {
 		zap.L().Error("register failed", zap.String("error", err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -87,40 +118,63 @@ func registerHandler(c *gin.Context) {
 		return
 	}
 	// 生成token
-	token, err := GenerateToken(user.ID, user.Username)
+	tokens, err := issueTokenPair(&user)
 	if err != nil {
 		zap.L().Error("register failed", zap.String("error", "Token generate failed: "+err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Token generate failed: " + err.Error()})
 		return
 	}
 
+	if user.Email != "" {
+		if err := sendVerificationMail(&user); err != nil {
+			zap.L().Error("register failed", zap.String("error", "send verification mail: "+err.Error()), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
+		}
+	}
+
 	zap.L().Info("register successfully", zap.String("username", user.Username))
 	// 返回
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-	})
+	tokens["success"] = true
+	c.JSON(http.StatusOK, tokens)
 }
 
 // 登录
 func loginHandler(c *gin.Context) {
-	var user User
 	username := c.PostForm("username")
-	result := db.Where("username = ?", username).First(&user)
-	if result.Error != nil {
-		zap.L().Error("login failed", zap.String("error", username+" not exist"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user not exist"})
+	user, err := store.Users.GetByUsername(username)
+	if err == nil {
+		// 按数据库中的用户名计数, 数据库排序规则不区分大小写时也对应同一个账号
+		username = user.Username
+	}
+	if checkLoginLocked(c, username) {
 		return
 	}
-	// 比较密码
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.PostForm("password")))
-	if err != nil {
-		zap.L().Error("login failed", zap.String("error", "Password is not correct"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is not correct"})
+	// 用户不存在和密码错误返回同样的错误, 且都做一次 bcrypt 比较
+	hash := dummyPasswordHash()
+	if err == nil && user.Password != "" {
+		hash = []byte(user.Password)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(c.PostForm("password"))) != nil || err != nil || user.Password == "" {
+		reason := LoginBadPassword
+		if err != nil {
+			reason, user = LoginUnknownUser, nil
+		}
+		recordLoginFailure(c, username, user, reason)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": loginFailedMessage})
 		return
 	}
+	if user.TOTPEnabled {
+		// 开启两步验证时先返回 challenge token, 验证码通过后才清除失败计数并签发令牌
+		writeTwoFactorChallenge(c, user)
+		return
+	}
+	resetLoginFailures(username)
+	writeLoginSuccess(c, user)
+}
+
+// 签发令牌对并返回登录结果
+func writeLoginSuccess(c *gin.Context, user *User) {
 	// 生成token
-	token, err := GenerateToken(user.ID, user.Username)
+	tokens, err := issueTokenPair(user)
 	if err != nil {
 		zap.L().Error("login failed", zap.String("error", "Token generate failed"), zap.String("path", c.Request.URL.Path), zap.String("method", c.Request.Method))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Token generate failed"})
@@ -129,12 +183,16 @@ func loginHandler(c *gin.Context) {
 
 	zap.L().Info("login successfully", zap.Uint("userID", user.ID), zap.String("username", user.Username))
 	// 返回
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-		},
-	})
+	tokens["success"] = true
+	info := gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	}
+	// 绑定了钱包的用户同时返回地址
+	if user.WalletAddress != nil {
+		info["address"] = *user.WalletAddress
+	}
+	tokens["user"] = info
+	c.JSON(http.StatusOK, tokens)
 }